@@ -0,0 +1,317 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is an interface for the source of time used by a scheduler.
+//
+// The real-time clock returns times that carry a monotonic clock reading, so
+// the due times of tasks are not affected by changes to the wall clock. A
+// clock that does jump back will not make a scheduler wait for a task longer
+// than the task was scheduled to wait for.
+type Clock interface {
+	// Now returns the current time according to the clock.
+	Now() time.Time
+
+	// Timer returns a channel that will receive the current time once the
+	// duration d has elapsed on the clock. Receiving from the channel blocks
+	// until that moment.
+	Timer(d time.Duration) <-chan time.Time
+}
+
+// stoppable is implemented by the clocks of this package whose timers hold on
+// to resources until they fire, e.g. a runtime timer or a goroutine. The stop
+// function returned with the channel releases those resources when the timer
+// is no longer waited for. It is safe to call stop after the timer fired.
+type stoppable interface {
+	stoppableTimer(d time.Duration) (fired <-chan time.Time, stop func())
+}
+
+// startTimer returns a channel that receives the time once the duration d has
+// elapsed on the clock, like Timer does, and a function to call when the
+// timer is no longer waited for. For a clock that does not implement
+// stoppable, the stop function does nothing.
+func startTimer(c Clock, d time.Duration) (<-chan time.Time, func()) {
+	if c, ok := c.(stoppable); ok {
+		return c.stoppableTimer(d)
+	}
+	return c.Timer(d), func() {}
+}
+
+// realtime
+
+type realtime struct{}
+
+func (realtime) Now() time.Time {
+	return time.Now()
+}
+
+func (realtime) Timer(d time.Duration) <-chan time.Time {
+	return time.NewTimer(d).C
+}
+
+func (realtime) stoppableTimer(d time.Duration) (<-chan time.Time, func()) {
+	timer := time.NewTimer(d)
+	return timer.C, func() { timer.Stop() }
+}
+
+// virtualclock
+
+type virtualclock struct {
+	now time.Time
+}
+
+func (c *virtualclock) Now() time.Time {
+	return c.now
+}
+
+func (c *virtualclock) Timer(d time.Duration) <-chan time.Time {
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	fired := make(chan time.Time, 1)
+	fired <- c.now
+	return fired
+}
+
+// spyclock
+
+type spyclock struct {
+	sync.Mutex
+	now   time.Time
+	calls []time.Time
+}
+
+// MakeSpyClock creates and returns a Clock for tests that starts at the given
+// time and records every call to Now. The clock only moves when Advance is
+// called or when a timer is requested from it. A timer moves the clock
+// forward to the moment it fires and then fires immediately, so a scheduler
+// using the clock never actually waits.
+func MakeSpyClock(start time.Time) *spyclock {
+	return &spyclock{now: start}
+}
+
+func (c *spyclock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	c.calls = append(c.calls, c.now)
+	return c.now
+}
+
+func (c *spyclock) Timer(d time.Duration) <-chan time.Time {
+	c.Lock()
+	defer c.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	fired := make(chan time.Time, 1)
+	fired <- c.now
+	return fired
+}
+
+// Advance moves the clock forward by d.
+func (c *spyclock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// NowCalls returns the number of times Now was called.
+func (c *spyclock) NowCalls() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.calls)
+}
+
+// Calls returns the times returned by the calls to Now, in the order the
+// calls were made.
+func (c *spyclock) Calls() []time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return append([]time.Time(nil), c.calls...)
+}
+
+// scaledclock
+
+// scaledclock runs at a multiple of the speed of the real-time clock. The
+// scale may change at any moment, also while a timer is waiting.
+type scaledclock struct {
+	sync.Mutex
+	base    time.Time
+	anchor  time.Time
+	scale   float64
+	changed chan struct{}
+}
+
+func newScaledClock(scale float64) *scaledclock {
+	now := time.Now()
+	return &scaledclock{base: now, anchor: now, scale: scale, changed: make(chan struct{})}
+}
+
+func (c *scaledclock) now() time.Time {
+	return c.base.Add(time.Duration(float64(time.Since(c.anchor)) * c.scale))
+}
+
+func (c *scaledclock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now()
+}
+
+// Timer returns a channel that receives the time once the clock has advanced
+// by d. Whenever the scale changes while waiting, the real time left to wait
+// is computed again. At a scale of zero the clock stands still and the timer
+// will not fire until the scale is raised.
+func (c *scaledclock) Timer(d time.Duration) <-chan time.Time {
+	fired, _ := c.stoppableTimer(d)
+	return fired
+}
+
+// stoppableTimer starts a timer like Timer does. The goroutine that waits for
+// the timer to fire exits when stop is called, also at a scale of zero.
+func (c *scaledclock) stoppableTimer(d time.Duration) (<-chan time.Time, func()) {
+	c.Lock()
+	target := c.now().Add(d)
+	c.Unlock()
+	fired := make(chan time.Time, 1)
+	stopped := make(chan struct{})
+	go func() {
+		for {
+			c.Lock()
+			now, scale, changed := c.now(), c.scale, c.changed
+			c.Unlock()
+			if !now.Before(target) {
+				fired <- now
+				return
+			}
+			if scale <= 0 {
+				select {
+				case <-changed:
+					continue
+				case <-stopped:
+					return
+				}
+			}
+			timer := time.NewTimer(time.Duration(float64(target.Sub(now))/scale) + 1)
+			select {
+			case <-timer.C:
+			case <-changed:
+				timer.Stop()
+			case <-stopped:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return fired, func() { once.Do(func() { close(stopped) }) }
+}
+
+// setScale changes the scale of the clock from now on and wakes up the
+// timers, so they can compute the real time left to wait.
+func (c *scaledclock) setScale(scale float64) {
+	if scale < 0 {
+		scale = 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.base, c.anchor = c.now(), time.Now()
+	c.scale = scale
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// tickclock
+
+// tickclock is driven by the times received from a channel. The first call
+// to Now waits for the first tick, which sets the start time of the clock.
+// After that the clock only moves while a timer is waiting, by consuming
+// ticks on a goroutine until the timer is due. Once the channel is closed,
+// the closed channel of the clock is closed too and the timers started from
+// then on never fire.
+type tickclock struct {
+	sync.Mutex
+	now     time.Time
+	started bool
+	ticks   <-chan time.Time
+	closed  chan struct{}
+}
+
+func newTickClock(ticks <-chan time.Time) *tickclock {
+	return &tickclock{ticks: ticks, closed: make(chan struct{})}
+}
+
+func (c *tickclock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	if !c.started {
+		c.started = true
+		tick, ok := <-c.ticks
+		c.apply(tick, ok)
+	}
+	return c.now
+}
+
+// apply moves the clock forward to the tick, or closes the closed channel
+// when the ticks channel was closed. It must be called with the lock held.
+func (c *tickclock) apply(tick time.Time, ok bool) {
+	if !ok {
+		select {
+		case <-c.closed:
+		default:
+			close(c.closed)
+		}
+		return
+	}
+	if tick.After(c.now) {
+		c.now = tick
+	}
+}
+
+func (c *tickclock) Timer(d time.Duration) <-chan time.Time {
+	fired, _ := c.stoppableTimer(d)
+	return fired
+}
+
+// stoppableTimer starts a timer like Timer does. The ticks are consumed on a
+// goroutine, so the timer is returned right away and the scheduler can stop
+// waiting for it, e.g. when the task is cancelled. Calling stop ends the
+// goroutine and only returns once it has ended, so a tick is never consumed
+// by a timer that is no longer waited for after stop returned.
+func (c *tickclock) stoppableTimer(d time.Duration) (<-chan time.Time, func()) {
+	target := c.Now().Add(d)
+	fired := make(chan time.Time, 1)
+	stopped := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			c.Lock()
+			now := c.now
+			c.Unlock()
+			if !now.Before(target) {
+				fired <- now
+				return
+			}
+			select {
+			case tick, ok := <-c.ticks:
+				c.Lock()
+				c.apply(tick, ok)
+				c.Unlock()
+				if !ok {
+					return
+				}
+			case <-stopped:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() { close(stopped) })
+		<-exited
+	}
+	return fired, stop
+}
@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// panics
+
+type panics struct {
+	sync.Mutex
+	inner  Scheduler
+	values []interface{}
+}
+
+// MakePanicCollector creates and returns a scheduler that dispatches its tasks
+// on the inner scheduler, but recovers from a panic in a task and collects the
+// value passed to panic. The returned instance implements the Scheduler
+// interface. Call Panics to get the values collected so far. It is intended
+// as an aid for testing error paths.
+func MakePanicCollector(inner Scheduler) *panics {
+	return &panics{inner: inner}
+}
+
+// Panics returns a copy of the values passed to panic by the tasks, in the
+// order the panics occurred.
+func (s *panics) Panics() []interface{} {
+	s.Lock()
+	defer s.Unlock()
+	return append([]interface{}(nil), s.values...)
+}
+
+func (s *panics) collect() {
+	if e := recover(); e != nil {
+		s.Lock()
+		s.values = append(s.values, e)
+		s.Unlock()
+	}
+}
+
+func (s *panics) Now() time.Time {
+	return s.inner.Now()
+}
+
+func (s *panics) Since(t time.Time) time.Duration {
+	return s.inner.Since(t)
+}
+
+func (s *panics) Schedule(task func()) Runner {
+	return s.inner.Schedule(func() {
+		defer s.collect()
+		task()
+	})
+}
+
+func (s *panics) ScheduleRecursive(task func(self func())) Runner {
+	return s.inner.ScheduleRecursive(func(self func()) {
+		defer s.collect()
+		task(self)
+	})
+}
+
+func (s *panics) ScheduleFuture(due time.Duration, task func()) Runner {
+	return s.inner.ScheduleFuture(due, func() {
+		defer s.collect()
+		task()
+	})
+}
+
+func (s *panics) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	return s.inner.ScheduleFutureRecursive(due, func(self func(time.Duration)) {
+		defer s.collect()
+		task(self)
+	})
+}
+
+func (s *panics) Wait() {
+	s.inner.Wait()
+}
+
+func (s *panics) Gosched() {
+	s.inner.Gosched()
+}
+
+// IsConcurrent returns whether the inner scheduler is concurrent. The values
+// are collected under a lock, so tasks that panic concurrently are safe.
+func (s *panics) IsConcurrent() bool {
+	return s.inner.IsConcurrent()
+}
+
+func (s *panics) Count() int {
+	return s.inner.Count()
+}
+
+func (s *panics) String() string {
+	s.Lock()
+	defer s.Unlock()
+	return fmt.Sprintf("PanicCollector{ panics = %d, inner = %v }", len(s.values), s.inner)
+}
@@ -0,0 +1,294 @@
+package scheduler
+
+import (
+	"sync"
+)
+
+// CompositeRunner is a Runner that groups other runners so they can be
+// cancelled as a unit. The zero value is an empty group ready to use. It is
+// safe to use a CompositeRunner from multiple goroutines concurrently.
+type CompositeRunner struct {
+	mu      sync.Mutex
+	runners []Runner
+	cause   error
+	done    chan struct{}
+}
+
+// WhenAll returns a CompositeRunner that groups the given runners, so they
+// can be awaited and cancelled as a unit. Its Done channel is closed once the
+// Done channels of all runners are closed, i.e. when every runner has either
+// finished or been cancelled. Cancelling it cancels all runners. Calling
+// WhenAll without runners returns a group that is done immediately.
+func WhenAll(runners ...Runner) *CompositeRunner {
+	return &CompositeRunner{runners: append([]Runner(nil), runners...)}
+}
+
+// Add adds the runner to the group. When the group was already cancelled,
+// the runner is not added but cancelled immediately instead, with the same
+// cause as the group.
+func (c *CompositeRunner) Add(runner Runner) {
+	c.mu.Lock()
+	if cause := c.cause; cause != nil {
+		c.mu.Unlock()
+		runner.CancelCause(cause)
+		return
+	}
+	c.runners = append(c.runners, runner)
+	c.mu.Unlock()
+}
+
+// Remove removes the runner from the group without cancelling it.
+func (c *CompositeRunner) Remove(runner Runner) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, r := range c.runners {
+		if r == runner {
+			c.runners = append(c.runners[:i], c.runners[i+1:]...)
+			return
+		}
+	}
+}
+
+// Cancel cancels all runners in the group. It returns true when cancelling
+// the runners prevented at least one of them from running. It is safe to call
+// Cancel more than once and from multiple goroutines concurrently, only the
+// first call has any effect.
+func (c *CompositeRunner) Cancel() bool {
+	prevented := false
+	for _, r := range c.take(ErrCancelled) {
+		if r.Cancel() {
+			prevented = true
+		}
+	}
+	return prevented
+}
+
+// CancelCause cancels all runners in the group like Cancel does and sets the
+// cause of the cancellation of the group and of its runners to err, or to
+// ErrCancelled when err is nil.
+func (c *CompositeRunner) CancelCause(err error) {
+	if err == nil {
+		err = ErrCancelled
+	}
+	for _, r := range c.take(err) {
+		r.CancelCause(err)
+	}
+}
+
+// take marks the group as cancelled with the given cause and returns its
+// runners, or nil when the group was already cancelled.
+func (c *CompositeRunner) take(err error) []Runner {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cause != nil {
+		return nil
+	}
+	c.cause = err
+	runners := c.runners
+	c.runners = nil
+	return runners
+}
+
+// IsCancelled returns true when Cancel has been called on the group.
+func (c *CompositeRunner) IsCancelled() bool {
+	return c.Cause() != nil
+}
+
+// Cause returns the cause of the cancellation of the group, or nil when the
+// group has not been cancelled.
+func (c *CompositeRunner) Cause() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cause
+}
+
+// Done returns a channel that is closed once every runner in the group is
+// done, or when the group was cancelled. Runners that are added after the
+// channel was closed are not waited for.
+func (c *CompositeRunner) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done == nil {
+		c.done = make(chan struct{})
+		go c.wait()
+	}
+	return c.done
+}
+
+func (c *CompositeRunner) wait() {
+	for {
+		c.mu.Lock()
+		if c.cause != nil || len(c.runners) == 0 {
+			close(c.done)
+			c.mu.Unlock()
+			return
+		}
+		runner := c.runners[0]
+		c.mu.Unlock()
+		<-runner.Done()
+		c.Remove(runner)
+	}
+}
+
+// anyrunner
+
+// anyrunner is a Runner that races other runners against each other.
+type anyrunner struct {
+	mu      sync.Mutex
+	runners []Runner
+	pending int
+	settled bool
+	cause   error
+	done    chan struct{}
+}
+
+// WhenAny returns a Runner that races the given runners against each other,
+// e.g. a task doing some work against a task that handles a timeout. Its
+// Done channel is closed as soon as one of the runners has finished, and
+// then the other runners are cancelled. A runner that was cancelled does not
+// win the race, but when all runners have been cancelled the Done channel is
+// closed as well. When a runner has already finished by the time WhenAny is
+// called, the other runners are cancelled and the returned runner is done
+// immediately. Cancelling the returned runner before the race is over cancels
+// all runners. Calling WhenAny without runners returns a runner that is done
+// immediately.
+//
+// The runners of the schedulers in this package are cancelled right when the
+// winner finishes, on the goroutine that ran it. On a trampoline the losers
+// are thus cancelled before the next task is dispatched, even when they were
+// already due. Other runners are watched from a goroutine, which exits once
+// the race is over.
+func WhenAny(runners ...Runner) *anyrunner {
+	r := &anyrunner{
+		runners: append([]Runner(nil), runners...),
+		pending: len(runners),
+		done:    make(chan struct{}),
+	}
+	if len(runners) == 0 {
+		r.settled = true
+		close(r.done)
+		return r
+	}
+	for _, c := range runners {
+		select {
+		case <-c.Done():
+			if !c.IsCancelled() {
+				r.finish(c)
+				return r
+			}
+		default:
+		}
+	}
+	for _, c := range runners {
+		c := c
+		if t, ok := c.(*futuretask); ok {
+			if !t.whenDone(func() { r.settle(c) }) {
+				r.settle(c)
+			}
+		} else {
+			go r.watch(c)
+		}
+	}
+	return r
+}
+
+// watch waits for the runner to be done and then settles the race. It stops
+// waiting once the race is over.
+func (r *anyrunner) watch(c Runner) {
+	select {
+	case <-c.Done():
+		r.settle(c)
+	case <-r.done:
+	}
+}
+
+// settle settles the race when the runner, which is done, has finished or
+// when it was the last runner to be cancelled.
+func (r *anyrunner) settle(c Runner) {
+	if c.IsCancelled() {
+		r.mu.Lock()
+		r.pending--
+		if !r.settled && r.pending == 0 {
+			r.settled = true
+			close(r.done)
+		}
+		r.mu.Unlock()
+		return
+	}
+	r.finish(c)
+}
+
+// finish settles the race with the runner as the winner and cancels the
+// other runners. It does nothing when the race was already settled.
+func (r *anyrunner) finish(winner Runner) {
+	r.mu.Lock()
+	if r.settled {
+		r.mu.Unlock()
+		return
+	}
+	r.settled = true
+	close(r.done)
+	runners := r.runners
+	r.runners = nil
+	r.mu.Unlock()
+	for _, c := range runners {
+		if c != winner {
+			c.Cancel()
+		}
+	}
+}
+
+// Cancel cancels all runners when the race is not over yet. It returns true
+// when cancelling the runners prevented at least one of them from running.
+func (r *anyrunner) Cancel() bool {
+	prevented := false
+	for _, c := range r.take(ErrCancelled) {
+		if c.Cancel() {
+			prevented = true
+		}
+	}
+	return prevented
+}
+
+// CancelCause cancels all runners like Cancel does and sets the cause of the
+// cancellation to err, or to ErrCancelled when err is nil.
+func (r *anyrunner) CancelCause(err error) {
+	if err == nil {
+		err = ErrCancelled
+	}
+	for _, c := range r.take(err) {
+		c.CancelCause(err)
+	}
+}
+
+// take settles the race as cancelled with the given cause and returns the
+// runners, or nil when the race was already settled.
+func (r *anyrunner) take(err error) []Runner {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.settled {
+		return nil
+	}
+	r.settled = true
+	r.cause = err
+	close(r.done)
+	runners := r.runners
+	r.runners = nil
+	return runners
+}
+
+func (r *anyrunner) Cause() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cause
+}
+
+func (r *anyrunner) IsCancelled() bool {
+	return r.Cause() != nil
+}
+
+// Done returns a channel that is closed once one of the runners has finished,
+// once all of them have been cancelled or when the race was cancelled.
+func (r *anyrunner) Done() <-chan struct{} {
+	return r.done
+}
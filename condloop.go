@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// condloop
+
+type condloop struct {
+	serial
+	gid     string
+	cancels int32
+	wake    *sync.Cond
+}
+
+// MakeCondLoop creates and returns a serial scheduler that runs its tasks on
+// the goroutine that calls Wait. The returned instance implements the
+// Scheduler interface. Unlike the Trampoline scheduler, it is safe to call
+// the scheduling methods from multiple goroutines concurrently. While Wait
+// waits for a future task to become due, it sleeps on a sync.Cond. Scheduling
+// a task signals the cond, so Wait wakes up and computes the time to sleep
+// again, in case the new task is due earlier.
+//
+// Cancelling a future task from another goroutine does not wake up Wait. The
+// task is removed from the queue when Wait wakes up for another reason.
+func MakeCondLoop() *condloop {
+	s := &condloop{}
+	s.queue = s.enqueue
+	s.wake = sync.NewCond(s)
+	return s
+}
+
+func (s *condloop) enqueue(t *futuretask, due time.Duration) {
+	s.Lock()
+	if t.owner() == nil {
+		t.own(&s.cancels)
+	}
+	s.push(t, due)
+	s.wake.Signal()
+	s.Unlock()
+}
+
+func (s *condloop) Now() time.Time {
+	return time.Now()
+}
+
+func (s *condloop) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+// Wait runs the tasks in the queue on the calling goroutine until the queue is
+// empty. Calling Wait from a task that is run by Wait returns immediately,
+// the outer call will continue running the remaining tasks after the task
+// returns. Only one goroutine should call Wait at a time.
+func (s *condloop) Wait() {
+	s.Lock()
+	defer s.Unlock()
+	gid := Gid()
+	if s.current != nil && s.gid == gid {
+		return
+	}
+	s.gid = gid
+	for {
+		if atomic.SwapInt32(&s.cancels, 0) != 0 {
+			s.tasks.prune()
+		}
+		task := s.tasks.peek()
+		if task == nil {
+			return
+		}
+		if due := time.Until(task.at); due > 0 {
+			timer := time.AfterFunc(due, func() {
+				s.Lock()
+				s.wake.Signal()
+				s.Unlock()
+			})
+			s.wake.Wait()
+			timer.Stop()
+			continue
+		}
+		s.dispatch(task)
+	}
+}
+
+func (s *condloop) Gosched() {
+	runtime.Gosched()
+}
+
+func (s *condloop) IsConcurrent() bool {
+	return true
+}
+
+func (s *condloop) String() string {
+	s.Lock()
+	gid := s.gid
+	s.Unlock()
+	return fmt.Sprintf("CondLoop{ gid = %s, tasks = %d }", gid, s.Count())
+}
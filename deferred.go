@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// deferredclock is frozen at the moment it was created until it is started,
+// from then on it follows the real-time clock.
+type deferredclock struct {
+	frozen  time.Time
+	started bool
+}
+
+func (c *deferredclock) Now() time.Time {
+	if !c.started {
+		return c.frozen
+	}
+	return time.Now()
+}
+
+func (c *deferredclock) Timer(d time.Duration) <-chan time.Time {
+	return time.NewTimer(d).C
+}
+
+func (c *deferredclock) stoppableTimer(d time.Duration) (<-chan time.Time, func()) {
+	timer := time.NewTimer(d)
+	return timer.C, func() { timer.Stop() }
+}
+
+// deferred
+
+type deferred struct {
+	*trampoline
+	clock *deferredclock
+}
+
+// MakeDeferred creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that runs nothing until Start is called. This
+// supports building e.g. a pipeline of subscriptions eagerly and running it
+// later. Calling Wait before Start returns immediately.
+//
+// The due time of a task scheduled before Start is relative to the moment
+// Start is called and not to the moment the task was scheduled, so
+// ScheduleFuture(time.Second, task) runs task one second after Start. Those
+// tasks run in the same order as they would have without the delay. Tasks
+// scheduled after Start behave as they would on a trampoline.
+func MakeDeferred() *deferred {
+	clock := &deferredclock{frozen: time.Now()}
+	s := &deferred{MakeTrampolineWithClock(clock), clock}
+	s.Pause()
+	return s
+}
+
+// Start moves the due times of the tasks scheduled so far to be relative to
+// the current time and then runs the tasks like Wait does. Calling Start
+// again behaves like calling Wait.
+func (s *deferred) Start() {
+	if !s.clock.started {
+		now := time.Now()
+		s.tasks.shift(now.Sub(s.clock.frozen))
+		s.clock.started = true
+		s.Resume()
+	}
+	s.Wait()
+}
+
+// Reset returns the scheduler to the state it was in when it was created,
+// like the Reset of a trampoline does. The scheduler then runs nothing until
+// Start is called again, and the due times of the tasks scheduled until then
+// are relative to that call.
+func (s *deferred) Reset() {
+	s.trampoline.Reset()
+	s.clock.frozen = time.Now()
+	s.clock.started = false
+	s.Pause()
+}
+
+// IsStarted returns true once Start has been called.
+func (s *deferred) IsStarted() bool {
+	return s.clock.started
+}
+
+func (s *deferred) String() string {
+	return fmt.Sprintf("Deferred{ gid = %s, started = %v, tasks = %d }", s.gid, s.clock.started, s.SafeLen())
+}
@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// eventloop
+
+type eventloop struct {
+	serial
+	gid      string
+	shutdown bool
+	wake     chan struct{}
+	idle     *sync.Cond
+	done     chan struct{}
+}
+
+// MakeEventLoop creates and returns a scheduler that runs all tasks serially
+// on a single goroutine owned by the scheduler. The returned instance
+// implements the Scheduler interface. Unlike the Trampoline scheduler, it is
+// safe to call the scheduling methods from multiple goroutines concurrently.
+// Scheduling a task wakes up the loop, which will then run the task on the
+// loop goroutine in dispatch order.
+//
+// Call Shutdown to stop the loop goroutine once all tasks have run.
+func MakeEventLoop() *eventloop {
+	s := &eventloop{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	s.queue = s.enqueue
+	s.idle = sync.NewCond(s)
+	started := make(chan struct{})
+	go s.loop(started)
+	<-started
+	return s
+}
+
+func (s *eventloop) loop(started chan struct{}) {
+	defer close(s.done)
+	s.Lock()
+	s.gid = Gid()
+	close(started)
+	for {
+		task := s.tasks.peek()
+		if task == nil {
+			s.idle.Broadcast()
+			if s.shutdown {
+				s.Unlock()
+				return
+			}
+			s.Unlock()
+			<-s.wake
+			s.Lock()
+			continue
+		}
+		if due := time.Until(task.at); due > 0 && !task.IsCancelled() {
+			s.Unlock()
+			deadline := time.NewTimer(due)
+			select {
+			case <-task.cancelChan():
+			case <-deadline.C:
+			case <-s.wake:
+				// a new task may have become the head of the queue
+			}
+			deadline.Stop()
+			s.Lock()
+			continue
+		}
+		s.dispatch(task)
+	}
+}
+
+func (s *eventloop) enqueue(t *futuretask, due time.Duration) {
+	s.Lock()
+	if s.shutdown {
+		t.CancelCause(ErrShutdown)
+	} else {
+		s.push(t, due)
+	}
+	s.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *eventloop) Now() time.Time {
+	return time.Now()
+}
+
+func (s *eventloop) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+// Wait blocks until all tasks have run. Calling Wait from a task running on
+// the loop goroutine returns immediately, the loop will continue running the
+// remaining tasks after the task returns.
+func (s *eventloop) Wait() {
+	s.Lock()
+	defer s.Unlock()
+	if s.gid == Gid() {
+		return
+	}
+	for s.tasks.Len() > 0 || s.current != nil {
+		s.idle.Wait()
+	}
+}
+
+// Shutdown stops the loop goroutine once all tasks have run and then returns.
+// Tasks scheduled after Shutdown was called are cancelled immediately. When
+// called from a task running on the loop goroutine, Shutdown returns without
+// waiting for the loop to stop.
+func (s *eventloop) Shutdown() {
+	s.Lock()
+	s.shutdown = true
+	s.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	if s.gid != Gid() {
+		<-s.done
+	}
+}
+
+func (s *eventloop) Gosched() {
+	runtime.Gosched()
+}
+
+func (s *eventloop) IsConcurrent() bool {
+	return true
+}
+
+func (s *eventloop) String() string {
+	return fmt.Sprintf("EventLoop{ gid = %s, tasks = %d }", s.gid, s.Count())
+}
@@ -22,14 +22,6 @@ func MakeGoroutine() *goroutine {
 	return &goroutine{}
 }
 
-// cancel
-
-type cancel chan struct{}
-
-func (c cancel) Cancel() {
-	close(c)
-}
-
 // goroutine
 
 type goroutine struct {
@@ -48,20 +40,30 @@ func (s *goroutine) Since(t time.Time) time.Duration {
 }
 
 func (s *goroutine) Schedule(task func()) Runner {
-	cancel := make(cancel)
+	t := newtask(task)
 	atomic.AddInt32(&s.active, 1)
 	s.concurrent.Add(1)
 	go func() {
 		defer atomic.AddInt32(&s.active, -1)
 		defer s.concurrent.Done()
-		select {
-		case <-cancel:
-			// cancel
-		default:
+		if t.begin() {
 			task()
+			t.finish()
 		}
 	}()
-	return cancel
+	return t
+}
+
+// ScheduleTimeout dispatches a task to the scheduler like Schedule does. A
+// watchdog calls onTimeout on a separate goroutine as soon as the task has
+// been running for longer than timeout. The task itself is not interrupted
+// and keeps running until it returns.
+func (s *goroutine) ScheduleTimeout(timeout time.Duration, task func(), onTimeout func()) Runner {
+	return s.Schedule(func() {
+		watchdog := time.AfterFunc(timeout, onTimeout)
+		defer watchdog.Stop()
+		task()
+	})
 }
 
 func (s *goroutine) ScheduleRecursive(task func(self func())) Runner {
@@ -79,7 +81,7 @@ func (s *goroutine) ScheduleRecursive(task func(self func())) Runner {
 }
 
 func (s *goroutine) ScheduleFuture(due time.Duration, task func()) Runner {
-	cancel := make(cancel)
+	t := newtask(task)
 	atomic.AddInt32(&s.active, 1)
 	s.concurrent.Add(1)
 	go func() {
@@ -88,21 +90,22 @@ func (s *goroutine) ScheduleFuture(due time.Duration, task func()) Runner {
 		if due > 0 {
 			due := time.NewTimer(due)
 			select {
-			case <-cancel:
+			case <-t.cancelChan():
 				due.Stop()
 			case <-due.C:
-				task()
+				if t.begin() {
+					task()
+					t.finish()
+				}
 			}
 		} else {
-			select {
-			case <-cancel:
-				// cancel
-			default:
+			if t.begin() {
 				task()
+				t.finish()
 			}
 		}
 	}()
-	return cancel
+	return t
 }
 
 func (s *goroutine) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// schedulergroup
+
+type schedulergroup struct {
+	shards []*eventloop
+}
+
+// MakeSchedulerGroup creates and returns a group of shards event loops, each
+// running on its own goroutine. Tasks are routed to a shard by hashing the
+// key they are scheduled with, so tasks with the same key run serially in the
+// order they were scheduled, while tasks with different keys may run in
+// parallel. Like the EventLoop scheduler, it is safe to schedule tasks on the
+// group from multiple goroutines concurrently.
+//
+// Call Shutdown to stop the goroutines of the shards once all tasks have run.
+func MakeSchedulerGroup(shards int) *schedulergroup {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &schedulergroup{make([]*eventloop, shards)}
+	for i := range s.shards {
+		s.shards[i] = MakeEventLoop()
+	}
+	return s
+}
+
+// Shard returns the scheduler that runs the tasks scheduled with the key.
+func (s *schedulergroup) Shard(key string) Scheduler {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// ScheduleKeyed dispatches a task to the shard for the key.
+func (s *schedulergroup) ScheduleKeyed(key string, task func()) Runner {
+	return s.Shard(key).Schedule(task)
+}
+
+// Wait blocks until the tasks on all shards have run.
+func (s *schedulergroup) Wait() {
+	for _, shard := range s.shards {
+		shard.Wait()
+	}
+}
+
+// Shutdown stops the goroutines of all shards once all tasks have run.
+func (s *schedulergroup) Shutdown() {
+	for _, shard := range s.shards {
+		shard.Shutdown()
+	}
+}
+
+func (s *schedulergroup) Count() int {
+	count := 0
+	for _, shard := range s.shards {
+		count += shard.Count()
+	}
+	return count
+}
+
+func (s *schedulergroup) String() string {
+	return fmt.Sprintf("SchedulerGroup{ shards = %d, tasks = %d }", len(s.shards), s.Count())
+}
@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// immediate
+
+type immediate struct {
+	active int32
+}
+
+// MakeImmediate creates and returns a scheduler that runs every task
+// synchronously on the goroutine that schedules it, before the schedule method
+// returns. The returned instance implements the Scheduler interface. Future
+// tasks are run after sleeping for the due time. Because nothing is ever
+// queued, calling Wait returns immediately.
+//
+// The self function passed to a recursive task calls the task directly, so
+// every iteration of a recursive task adds to the depth of the call stack.
+// Use the Trampoline scheduler for long running recursive algorithms.
+func MakeImmediate() *immediate {
+	return &immediate{}
+}
+
+func (s *immediate) run(t *futuretask) {
+	atomic.AddInt32(&s.active, 1)
+	defer atomic.AddInt32(&s.active, -1)
+	if t.begin() {
+		t.run()
+	}
+	t.finish()
+}
+
+func (s *immediate) Now() time.Time {
+	return time.Now()
+}
+
+func (s *immediate) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (s *immediate) Schedule(task func()) Runner {
+	t := newtask(task)
+	s.run(t)
+	return t
+}
+
+func (s *immediate) ScheduleRecursive(task func(self func())) Runner {
+	t := newtask(nil)
+	var self func()
+	run := func() {
+		if !t.IsCancelled() {
+			task(self)
+		}
+	}
+	self = func() {
+		if !t.IsCancelled() {
+			t.iterate()
+		}
+		run()
+	}
+	t.run = run
+	s.run(t)
+	return t
+}
+
+func (s *immediate) ScheduleFuture(due time.Duration, task func()) Runner {
+	t := newtask(func() {
+		time.Sleep(due)
+		task()
+	})
+	s.run(t)
+	return t
+}
+
+func (s *immediate) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	t := newtask(nil)
+	var self func(time.Duration)
+	run := func(due time.Duration) {
+		time.Sleep(due)
+		if !t.IsCancelled() {
+			task(self)
+		}
+	}
+	self = func(due time.Duration) {
+		if !t.IsCancelled() {
+			t.iterate()
+		}
+		run(due)
+	}
+	t.run = func() {
+		run(due)
+	}
+	s.run(t)
+	return t
+}
+
+func (s *immediate) Wait() {
+}
+
+func (s *immediate) Gosched() {
+	runtime.Gosched()
+}
+
+func (s *immediate) IsConcurrent() bool {
+	return false
+}
+
+func (s *immediate) Count() int {
+	return int(atomic.LoadInt32(&s.active))
+}
+
+func (s *immediate) String() string {
+	return fmt.Sprintf("Immediate{ tasks = %d }", atomic.LoadInt32(&s.active))
+}
@@ -0,0 +1,187 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Histogram holds a distribution of durations. Counts[i] is the number of
+// durations that were at most Bounds[i] and larger than the previous bound.
+// The last entry of Counts, one beyond the bounds, counts the durations
+// larger than the last bound.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []int
+	Total  int
+	Max    time.Duration
+}
+
+// LatencyBounds are the upper bounds of the buckets of the latency histogram
+// of an instrumented scheduler, one per decade from 1µs up to 10s.
+var LatencyBounds = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+func (h *Histogram) add(d time.Duration) {
+	i := 0
+	for i < len(h.Bounds) && d > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Total++
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Percentile returns the upper bound of the bucket that holds the duration
+// below which p percent of the durations fall. For durations beyond the last
+// bound the largest duration is returned instead. An empty histogram returns
+// 0.
+func (h Histogram) Percentile(p float64) time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(h.Total))) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= h.Total {
+		rank = h.Total - 1
+	}
+	for i, n := range h.Counts {
+		if rank < n {
+			if i < len(h.Bounds) && h.Bounds[i] < h.Max {
+				return h.Bounds[i]
+			}
+			return h.Max
+		}
+		rank -= n
+	}
+	return h.Max
+}
+
+// instrumented
+
+type instrumented struct {
+	sync.Mutex
+	inner   Scheduler
+	latency Histogram
+}
+
+// MakeInstrumented creates and returns a scheduler that dispatches its tasks
+// on the inner scheduler and measures the latency of every task, i.e. how
+// long after the time it was due the task was actually dispatched. The
+// returned instance implements the Scheduler interface. Call Histogram to get
+// the distribution of latencies measured so far. Both times are taken from
+// the clock of the inner scheduler, so a virtual time scheduler will drive
+// the measurements deterministically.
+func MakeInstrumented(inner Scheduler) *instrumented {
+	return &instrumented{
+		inner: inner,
+		latency: Histogram{
+			Bounds: LatencyBounds,
+			Counts: make([]int, len(LatencyBounds)+1),
+		},
+	}
+}
+
+// Histogram returns a copy of the histogram of the latencies measured so far.
+func (s *instrumented) Histogram() Histogram {
+	s.Lock()
+	defer s.Unlock()
+	h := s.latency
+	h.Counts = append([]int(nil), h.Counts...)
+	return h
+}
+
+// measure records the latency of a task that was due at the given time and
+// is being dispatched now.
+func (s *instrumented) measure(at time.Time) {
+	latency := s.inner.Since(at)
+	if latency < 0 {
+		latency = 0
+	}
+	s.Lock()
+	s.latency.add(latency)
+	s.Unlock()
+}
+
+func (s *instrumented) Now() time.Time {
+	return s.inner.Now()
+}
+
+func (s *instrumented) Since(t time.Time) time.Duration {
+	return s.inner.Since(t)
+}
+
+func (s *instrumented) Schedule(task func()) Runner {
+	at := s.inner.Now()
+	return s.inner.Schedule(func() {
+		s.measure(at)
+		task()
+	})
+}
+
+func (s *instrumented) ScheduleRecursive(task func(self func())) Runner {
+	at := s.inner.Now()
+	return s.inner.ScheduleRecursive(func(self func()) {
+		s.measure(at)
+		task(func() {
+			at = s.inner.Now()
+			self()
+		})
+	})
+}
+
+func (s *instrumented) ScheduleFuture(due time.Duration, task func()) Runner {
+	at := s.inner.Now().Add(due)
+	return s.inner.ScheduleFuture(due, func() {
+		s.measure(at)
+		task()
+	})
+}
+
+func (s *instrumented) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	at := s.inner.Now().Add(due)
+	return s.inner.ScheduleFutureRecursive(due, func(self func(time.Duration)) {
+		s.measure(at)
+		task(func(due time.Duration) {
+			at = s.inner.Now().Add(due)
+			self(due)
+		})
+	})
+}
+
+func (s *instrumented) Wait() {
+	s.inner.Wait()
+}
+
+func (s *instrumented) Gosched() {
+	s.inner.Gosched()
+}
+
+// IsConcurrent returns whether the inner scheduler is concurrent. The
+// histogram is updated under a lock, so measuring tasks that run concurrently
+// is safe.
+func (s *instrumented) IsConcurrent() bool {
+	return s.inner.IsConcurrent()
+}
+
+func (s *instrumented) Count() int {
+	return s.inner.Count()
+}
+
+func (s *instrumented) String() string {
+	s.Lock()
+	defer s.Unlock()
+	return fmt.Sprintf("Instrumented{ tasks = %d, max = %v, inner = %v }", s.latency.Total, s.latency.Max, s.inner)
+}
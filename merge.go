@@ -0,0 +1,68 @@
+package scheduler
+
+import "fmt"
+
+// merge
+
+type merge struct {
+	children []*trampoline
+}
+
+// MakeMerge creates and returns a merge of the queues of the given
+// trampolines. Calling Wait on the merge runs the tasks of all children on the
+// calling goroutine in the global order of their due times, as if they were
+// queued on a single trampoline. Tasks that are due at the same time run in
+// order of their priority, and tasks with the same priority in the order of
+// the children. Tasks scheduled with ScheduleWhenIdle run once no child has
+// another task that is due. Tasks scheduled on a child while the merge is
+// running are merged in as well.
+func MakeMerge(children ...*trampoline) *merge {
+	return &merge{children}
+}
+
+// next returns the child with the task at the head of its queue that should
+// be dispatched first, or the first child with a task waiting for the
+// scheduler to become idle when no child has a task that is due. It returns
+// nil when the queues of all children that are not paused are empty.
+func (s *merge) next() *trampoline {
+	var next, idle *trampoline
+	var head *futuretask
+	for _, child := range s.children {
+		child.prune()
+		if child.IsPaused() {
+			continue
+		}
+		if t := child.tasks.peek(); t != nil && (head == nil || t.precedes(head)) {
+			next, head = child, t
+		}
+		if idle == nil && len(child.tasks.idle) > 0 {
+			idle = child
+		}
+	}
+	if idle != nil && (head == nil || head.at.After(next.clock.Now())) {
+		return idle
+	}
+	return next
+}
+
+// Wait runs the tasks of all children in order of their due times until the
+// queues of all children are empty.
+func (s *merge) Wait() {
+	for child := s.next(); child != nil; child = s.next() {
+		if !child.RunTask() {
+			break
+		}
+	}
+}
+
+func (s *merge) Count() int {
+	count := 0
+	for _, child := range s.children {
+		count += child.Count()
+	}
+	return count
+}
+
+func (s *merge) String() string {
+	return fmt.Sprintf("Merge{ children = %d, tasks = %d }", len(s.children), s.Count())
+}
@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// newthread
+
+type newthread struct {
+	threads sync.WaitGroup
+	active  int32
+}
+
+// MakeNewThread creates and returns a concurrent scheduler that starts a new
+// goroutine for every task scheduled on it. The returned instance implements
+// the Scheduler interface. Unlike the Goroutine scheduler, a recursive task
+// keeps running in a loop on the goroutine that was started for it. Calling
+// self does not start another goroutine, the task is simply run again by the
+// loop once it returns. Cancelling the task stops the loop.
+//
+// This makes the NewThread scheduler a good fit for long running tasks that
+// block, e.g. while reading from a network connection.
+func MakeNewThread() *newthread {
+	return &newthread{}
+}
+
+// start runs the task on a new goroutine after the given due time. The task
+// is run again on the same goroutine every time it calls self, until the task
+// returns without calling self or is cancelled.
+func (s *newthread) start(due time.Duration, task func(self func(time.Duration))) Runner {
+	t := newtask(nil)
+	atomic.AddInt32(&s.active, 1)
+	s.threads.Add(1)
+	go func() {
+		defer s.threads.Done()
+		defer atomic.AddInt32(&s.active, -1)
+		defer t.finish()
+		again := true
+		self := func(next time.Duration) {
+			if !t.IsCancelled() {
+				t.iterate()
+			}
+			again, due = true, next
+		}
+		for again {
+			again = false
+			if !t.sleep(due) || !t.begin() {
+				return
+			}
+			task(self)
+			if again {
+				t.rearm()
+			}
+		}
+	}()
+	return t
+}
+
+// sleep blocks for the due time or until the task is cancelled. It returns
+// false when the task was cancelled.
+func (t *futuretask) sleep(due time.Duration) bool {
+	if due <= 0 {
+		return !t.IsCancelled()
+	}
+	timer := time.NewTimer(due)
+	defer timer.Stop()
+	select {
+	case <-t.cancelChan():
+		return false
+	case <-timer.C:
+		return !t.IsCancelled()
+	}
+}
+
+func (s *newthread) Now() time.Time {
+	return time.Now()
+}
+
+func (s *newthread) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (s *newthread) Schedule(task func()) Runner {
+	return s.start(0, func(func(time.Duration)) {
+		task()
+	})
+}
+
+func (s *newthread) ScheduleRecursive(task func(self func())) Runner {
+	return s.start(0, func(self func(time.Duration)) {
+		task(func() { self(0) })
+	})
+}
+
+func (s *newthread) ScheduleFuture(due time.Duration, task func()) Runner {
+	return s.start(due, func(func(time.Duration)) {
+		task()
+	})
+}
+
+func (s *newthread) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	return s.start(due, task)
+}
+
+// Wait blocks until the goroutines started for all scheduled tasks have
+// returned.
+func (s *newthread) Wait() {
+	s.threads.Wait()
+}
+
+func (s *newthread) Gosched() {
+	runtime.Gosched()
+}
+
+func (s *newthread) IsConcurrent() bool {
+	return true
+}
+
+func (s *newthread) Count() int {
+	return int(atomic.LoadInt32(&s.active))
+}
+
+func (s *newthread) String() string {
+	return fmt.Sprintf("NewThread{ tasks = %d }", atomic.LoadInt32(&s.active))
+}
@@ -0,0 +1,82 @@
+package scheduler
+
+import "time"
+
+// noop
+
+type noop struct{}
+
+// MakeNoop creates and returns a scheduler that does nothing. The returned
+// instance implements the Scheduler interface. Its schedule methods neither
+// run nor record the task and return a shared runner that does nothing
+// either, Wait returns immediately. This allows measuring the overhead of code
+// that schedules tasks, e.g. a chain of reactive operators, in a benchmark
+// without also measuring the cost of scheduling and running the tasks.
+func MakeNoop() *noop {
+	return &noop{}
+}
+
+// noopRunner is the runner returned by the noop scheduler. Its task never
+// runs, so there is nothing to cancel and nothing to wait for.
+type noopRunner struct{}
+
+func (noopRunner) Cancel() bool {
+	return false
+}
+
+func (noopRunner) CancelCause(err error) {
+}
+
+func (noopRunner) Cause() error {
+	return nil
+}
+
+func (noopRunner) IsCancelled() bool {
+	return false
+}
+
+func (noopRunner) Done() <-chan struct{} {
+	return closed
+}
+
+func (s *noop) Now() time.Time {
+	return time.Now()
+}
+
+func (s *noop) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (s *noop) Schedule(task func()) Runner {
+	return noopRunner{}
+}
+
+func (s *noop) ScheduleRecursive(task func(self func())) Runner {
+	return noopRunner{}
+}
+
+func (s *noop) ScheduleFuture(due time.Duration, task func()) Runner {
+	return noopRunner{}
+}
+
+func (s *noop) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	return noopRunner{}
+}
+
+func (s *noop) Wait() {
+}
+
+func (s *noop) Gosched() {
+}
+
+func (s *noop) IsConcurrent() bool {
+	return false
+}
+
+func (s *noop) Count() int {
+	return 0
+}
+
+func (s *noop) String() string {
+	return "Noop{}"
+}
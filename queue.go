@@ -0,0 +1,439 @@
+package scheduler
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// before returns true when task t should be dispatched before task o. Tasks
+// are ordered by due time, then by priority with the highest priority first,
+// then by the round of their source and finally by sequence number.
+func (t *futuretask) before(o *futuretask) bool {
+	if !t.at.Equal(o.at) {
+		return t.at.Before(o.at)
+	}
+	if t.priority != o.priority {
+		return t.priority > o.priority
+	}
+	if t.round != o.round {
+		return t.round < o.round
+	}
+	return t.seq < o.seq
+}
+
+// precedes returns true when task t is due before task o, or at the same time
+// with a higher priority. Unlike before it ignores the round and the
+// sequence number, which only order the tasks within a single queue.
+func (t *futuretask) precedes(o *futuretask) bool {
+	if !t.at.Equal(o.at) {
+		return t.at.Before(o.at)
+	}
+	return t.priority > o.priority
+}
+
+// Values of futuretask.index for a task that is not stored in the heap.
+const (
+	unqueued = -1
+	inready  = -2
+	inidle   = -3
+)
+
+// queued returns true when the task is waiting in a taskqueue.
+func (t *futuretask) queued() bool {
+	return t.index != unqueued
+}
+
+// taskqueue
+
+// taskqueue holds the tasks waiting to be dispatched. Tasks that were already
+// due when they were scheduled are kept in a FIFO of ready tasks, so
+// scheduling and dispatching them takes constant time. All other tasks are
+// kept in a min-heap ordered by due time. The next task to dispatch is the
+// earlier of the tasks at the head of either.
+//
+// In fair mode the tasks in the FIFO of ready tasks are always dispatched
+// before any task in the heap, so a flood of future tasks that all become due
+// at once cannot hold up tasks scheduled to run immediately. In LIFO mode the
+// ready tasks are dispatched from the tail instead of the head, so they form a
+// stack.
+//
+// Tasks that should only run when the scheduler is idle are kept in a
+// separate FIFO, which is only consulted when no other task is due.
+type taskqueue struct {
+	ready    []*futuretask
+	head     int
+	future   taskheap
+	idle     []*futuretask
+	capacity int
+	fair     bool
+	lifo     bool
+}
+
+// reserve allocates room for capacity ready tasks and capacity future tasks
+// up front. The queue will not shrink its backing arrays below that size.
+func (q *taskqueue) reserve(capacity int) {
+	q.capacity = capacity
+	q.ready = make([]*futuretask, 0, capacity)
+	q.future = make(taskheap, 0, capacity)
+}
+
+// grow makes room for n more ready tasks, so appending them does not have to
+// reallocate the backing array more than once.
+func (q *taskqueue) grow(n int) {
+	if cap(q.ready)-len(q.ready) < n {
+		q.compact()
+		if cap(q.ready)-len(q.ready) < n {
+			q.ready = append(make([]*futuretask, 0, len(q.ready)+n), q.ready...)
+		}
+	}
+}
+
+func (q *taskqueue) Len() int {
+	return len(q.ready) - q.head + len(q.future) + len(q.idle)
+}
+
+// peek returns the next task to dispatch without removing it, or nil when the
+// queue is empty.
+func (q *taskqueue) peek() *futuretask {
+	var next *futuretask
+	if n := len(q.ready); q.head < n && q.lifo {
+		next = q.ready[n-1]
+	} else if q.head < n {
+		next = q.ready[q.head]
+	}
+	if len(q.future) > 0 && (next == nil || !q.fair && q.future[0].before(next)) {
+		next = q.future[0]
+	}
+	return next
+}
+
+// peekIdle returns the task that has waited longest for the scheduler to
+// become idle, or nil when there is no such task or when another task is due
+// at the given time.
+func (q *taskqueue) peekIdle(now time.Time) *futuretask {
+	if len(q.idle) == 0 {
+		return nil
+	}
+	if next := q.peek(); next != nil && !next.at.After(now) {
+		return nil
+	}
+	return q.idle[0]
+}
+
+// pop removes and returns the next task to dispatch, or nil when the queue is
+// empty.
+func (q *taskqueue) pop() *futuretask {
+	next := q.peek()
+	if next != nil {
+		q.remove(next)
+	}
+	return next
+}
+
+// put adds the task to the queue to run at the given time. The sequence
+// number orders it after any task already queued for the same time. A task
+// that is already queued is moved instead of being added a second time.
+func (q *taskqueue) put(t *futuretask, at time.Time, seq uint64) {
+	if t.index == inready || t.index == inidle {
+		q.remove(t)
+	}
+	t.at = at
+	t.seq = seq
+	if t.index == unqueued {
+		heap.Push(&q.future, t)
+	} else {
+		heap.Fix(&q.future, t.index)
+	}
+}
+
+// append adds a task that is due at the given time, which should not be
+// later than the current time, to the tail of the ready tasks. Should the
+// time be before that of the last ready task, e.g. because the clock was set
+// back, the task gets the time of the last ready task instead, so the ready
+// tasks stay in the order they were appended in. A task with a priority or a
+// round is put in the heap instead, to be ordered among the other tasks.
+func (q *taskqueue) append(t *futuretask, at time.Time, seq uint64) {
+	if t.priority != 0 || t.round != 0 {
+		q.put(t, at, seq)
+		return
+	}
+	if n := len(q.ready); n > q.head && at.Before(q.ready[n-1].at) {
+		at = q.ready[n-1].at
+	}
+	if t.queued() {
+		q.remove(t)
+	}
+	t.at = at
+	t.seq = seq
+	t.index = inready
+	q.ready = append(q.ready, t)
+}
+
+// appendIdle adds the task to the tail of the tasks that wait for the
+// scheduler to become idle.
+func (q *taskqueue) appendIdle(t *futuretask, at time.Time, seq uint64) {
+	if t.queued() {
+		q.remove(t)
+	}
+	t.at = at
+	t.seq = seq
+	t.index = inidle
+	q.idle = append(q.idle, t)
+}
+
+// remove takes the queued task out of the queue.
+func (q *taskqueue) remove(t *futuretask) {
+	switch {
+	case t.index >= 0:
+		heap.Remove(&q.future, t.index)
+		q.shrink()
+	case t.index == inready && q.ready[q.head] == t:
+		q.ready[q.head] = nil
+		q.head++
+		t.index = unqueued
+		q.compact()
+	case t.index == inready && q.ready[len(q.ready)-1] == t:
+		n := len(q.ready) - 1
+		q.ready[n] = nil
+		q.ready = q.ready[:n]
+		t.index = unqueued
+		q.compact()
+	case t.index == inready:
+		for i := q.head; i < len(q.ready); i++ {
+			if q.ready[i] == t {
+				n := len(q.ready) - 1
+				copy(q.ready[i:], q.ready[i+1:])
+				q.ready[n] = nil
+				q.ready = q.ready[:n]
+				break
+			}
+		}
+		t.index = unqueued
+	case t.index == inidle:
+		for i, c := range q.idle {
+			if c == t {
+				n := len(q.idle) - 1
+				copy(q.idle[i:], q.idle[i+1:])
+				q.idle[n] = nil
+				q.idle = q.idle[:n]
+				break
+			}
+		}
+		if len(q.idle) == 0 {
+			q.idle = nil
+		}
+		t.index = unqueued
+	}
+}
+
+// compact moves the ready tasks to the front of their backing array once the
+// space in front of them exceeds the space they occupy. The backing array is
+// released when there are no more ready tasks and it has grown larger than
+// the reserved capacity.
+func (q *taskqueue) compact() {
+	n := len(q.ready) - q.head
+	switch {
+	case n == 0 && cap(q.ready) > 64 && cap(q.ready) > q.capacity:
+		q.ready, q.head = nil, 0
+	case n == 0:
+		q.ready, q.head = q.ready[:0], 0
+	case q.head >= 32 && q.head >= n:
+		copy(q.ready, q.ready[q.head:])
+		for i := n; i < len(q.ready); i++ {
+			q.ready[i] = nil
+		}
+		q.ready, q.head = q.ready[:n], 0
+	}
+}
+
+// shrink reallocates the backing array of the heap when it has become much
+// larger than the number of future tasks, but not below the reserved capacity.
+func (q *taskqueue) shrink() {
+	if c := cap(q.future); c > 64 && c > q.capacity && len(q.future) < c/4 {
+		n := c / 2
+		if n < q.capacity {
+			n = q.capacity
+		}
+		q.future = append(make(taskheap, 0, n), q.future...)
+	}
+}
+
+// due returns the number of queued tasks that are due at or before now. The
+// ready tasks are all due, and the heap is only searched below tasks that are
+// due themselves, since the tasks below them cannot be due any earlier.
+func (q *taskqueue) due(now time.Time) int {
+	n := len(q.ready) - q.head
+	var walk func(i int)
+	walk = func(i int) {
+		if i < len(q.future) && !q.future[i].at.After(now) {
+			n++
+			walk(2*i + 1)
+			walk(2*i + 2)
+		}
+	}
+	walk(0)
+	return n
+}
+
+// shift moves the due time of every task in the queue by d. Since all tasks
+// move by the same amount, the order of the queue is unaffected.
+func (q *taskqueue) shift(d time.Duration) {
+	for _, t := range q.ready[q.head:] {
+		t.at = t.at.Add(d)
+	}
+	for _, t := range q.future {
+		t.at = t.at.Add(d)
+	}
+}
+
+// prune removes all cancelled tasks from the queue and returns them.
+func (q *taskqueue) prune() []*futuretask {
+	var pruned []*futuretask
+	ready := q.ready[:q.head]
+	for _, t := range q.ready[q.head:] {
+		if t.IsCancelled() {
+			t.index = unqueued
+			pruned = append(pruned, t)
+		} else {
+			ready = append(ready, t)
+		}
+	}
+	for i := len(ready); i < len(q.ready); i++ {
+		q.ready[i] = nil
+	}
+	q.ready = ready
+	future := q.future[:0]
+	for _, t := range q.future {
+		if t.IsCancelled() {
+			t.index = unqueued
+			pruned = append(pruned, t)
+		} else {
+			future = append(future, t)
+		}
+	}
+	for i := len(future); i < len(q.future); i++ {
+		q.future[i] = nil
+	}
+	q.future = future
+	for i, t := range q.future {
+		t.index = i
+	}
+	heap.Init(&q.future)
+	idle := q.idle[:0]
+	for _, t := range q.idle {
+		if t.IsCancelled() {
+			t.index = unqueued
+			pruned = append(pruned, t)
+		} else {
+			idle = append(idle, t)
+		}
+	}
+	for i := len(idle); i < len(q.idle); i++ {
+		q.idle[i] = nil
+	}
+	q.idle = idle
+	q.compact()
+	q.shrink()
+	return pruned
+}
+
+// drain removes all tasks from the queue and returns them.
+func (q *taskqueue) drain() []*futuretask {
+	tasks := append(q.ready[q.head:len(q.ready):len(q.ready)], q.future...)
+	tasks = append(tasks, q.idle...)
+	for _, t := range tasks {
+		t.index = unqueued
+	}
+	*q = taskqueue{capacity: q.capacity, fair: q.fair, lifo: q.lifo}
+	return tasks
+}
+
+// sorted returns the tasks in the order they will be dispatched, assuming
+// the tasks waiting for the scheduler to become idle run last.
+func (q *taskqueue) sorted() []*futuretask {
+	ready := append([]*futuretask(nil), q.ready[q.head:]...)
+	future := append([]*futuretask(nil), q.future...)
+	return append(q.merge(ready, future), q.idle...)
+}
+
+// sortedDue returns the tasks that are due at or before now in the order they
+// will be dispatched, leaving out the tasks waiting for the scheduler to
+// become idle. The heap is only searched below tasks that are due themselves.
+func (q *taskqueue) sortedDue(now time.Time) []*futuretask {
+	var ready, future []*futuretask
+	for _, t := range q.ready[q.head:] {
+		if !t.at.After(now) {
+			ready = append(ready, t)
+		}
+	}
+	var walk func(i int)
+	walk = func(i int) {
+		if i < len(q.future) && !q.future[i].at.After(now) {
+			future = append(future, q.future[i])
+			walk(2*i + 1)
+			walk(2*i + 2)
+		}
+	}
+	walk(0)
+	return q.merge(ready, future)
+}
+
+// merge returns the ready tasks, which are in the order they were appended
+// in, and the future tasks merged in dispatch order. It reorders both slices.
+func (q *taskqueue) merge(ready, future []*futuretask) []*futuretask {
+	if q.lifo {
+		for i, j := 0, len(ready)-1; i < j; i, j = i+1, j-1 {
+			ready[i], ready[j] = ready[j], ready[i]
+		}
+	}
+	sort.Slice(future, func(i, j int) bool { return future[i].before(future[j]) })
+	tasks := make([]*futuretask, 0, len(ready)+len(future))
+	for len(ready) > 0 && len(future) > 0 {
+		if !q.fair && future[0].before(ready[0]) {
+			tasks, future = append(tasks, future[0]), future[1:]
+		} else {
+			tasks, ready = append(tasks, ready[0]), ready[1:]
+		}
+	}
+	return append(append(tasks, ready...), future...)
+}
+
+// taskheap
+
+// taskheap implements heap.Interface as a min-heap of tasks ordered by their
+// due time. Tasks that are due at the same time are ordered by the sequence
+// number they were given when scheduled, so they run in dispatch order.
+type taskheap []*futuretask
+
+func (h taskheap) Len() int {
+	return len(h)
+}
+
+func (h taskheap) Less(i, j int) bool {
+	return h[i].before(h[j])
+}
+
+func (h taskheap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *taskheap) Push(x interface{}) {
+	t := x.(*futuretask)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+// Pop removes the last task. The vacated slot is cleared so the task can be
+// collected once it has run.
+func (h *taskheap) Pop() interface{} {
+	old := *h
+	n := len(old) - 1
+	t := old[n]
+	t.index = unqueued
+	old[n] = nil
+	*h = old[:n]
+	return t
+}
@@ -0,0 +1,170 @@
+package scheduler
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Call is a call to one of the schedule methods of a Recorder.
+type Call struct {
+	// Method is the name of the schedule method that was called, or "self"
+	// when a recursive task rescheduled itself.
+	Method string
+
+	// Due is the due time passed to the method, zero for immediate tasks.
+	Due time.Duration
+
+	// Runner is the runner that was returned for the task.
+	Runner Runner
+
+	// Fired is true when the task was run by calling Fire.
+	Fired bool
+
+	task *futuretask
+	run  func()
+}
+
+// recorder
+
+type recorder struct {
+	sync.Mutex
+	calls []Call
+}
+
+// MakeRecorder creates and returns a scheduler that does not run any tasks by
+// itself, but records every call to its schedule methods instead. The
+// returned instance implements the Scheduler interface. A test can inspect
+// the recorded calls and run them in any order by calling Fire, which gives
+// full control over the dispatch order without depending on the clock.
+//
+// Calling self from a recursive task that was fired records a new call with
+// method "self" that shares the runner of the original call. Calling Cancel
+// on a runner marks its recorded calls as cancelled, Fire will then refuse to
+// run them. Wait returns immediately.
+func MakeRecorder() *recorder {
+	return &recorder{}
+}
+
+func (s *recorder) record(method string, due time.Duration, t *futuretask, run func()) {
+	s.Lock()
+	s.calls = append(s.calls, Call{Method: method, Due: due, Runner: t, task: t, run: run})
+	s.Unlock()
+}
+
+// Calls returns a copy of the calls recorded so far in the order they were
+// made.
+func (s *recorder) Calls() []Call {
+	s.Lock()
+	defer s.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Len returns the number of calls recorded so far.
+func (s *recorder) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.calls)
+}
+
+// Fire runs the task of the recorded call at the given index on the calling
+// goroutine. It returns false without running the task when the call was
+// already fired or its runner was cancelled.
+func (s *recorder) Fire(index int) bool {
+	s.Lock()
+	call := &s.calls[index]
+	if call.Fired || !call.task.begin() {
+		s.Unlock()
+		return false
+	}
+	call.Fired = true
+	t, run, n := call.task, call.run, len(s.calls)
+	s.Unlock()
+	run()
+	s.Lock()
+	defer s.Unlock()
+	for _, c := range s.calls[n:] {
+		if c.task == t {
+			t.rearm()
+			return true
+		}
+	}
+	t.finish()
+	return true
+}
+
+func (s *recorder) Now() time.Time {
+	return time.Now()
+}
+
+func (s *recorder) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (s *recorder) Schedule(task func()) Runner {
+	t := newtask(task)
+	s.record("Schedule", 0, t, task)
+	return t
+}
+
+func (s *recorder) ScheduleRecursive(task func(self func())) Runner {
+	t := newtask(nil)
+	var self func()
+	self = func() {
+		if !t.IsCancelled() {
+			t.iterate()
+			s.record("self", 0, t, func() { task(self) })
+		}
+	}
+	s.record("ScheduleRecursive", 0, t, func() { task(self) })
+	return t
+}
+
+func (s *recorder) ScheduleFuture(due time.Duration, task func()) Runner {
+	t := newtask(task)
+	s.record("ScheduleFuture", due, t, task)
+	return t
+}
+
+func (s *recorder) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	t := newtask(nil)
+	var self func(time.Duration)
+	self = func(due time.Duration) {
+		if !t.IsCancelled() {
+			t.iterate()
+			s.record("self", due, t, func() { task(self) })
+		}
+	}
+	s.record("ScheduleFutureRecursive", due, t, func() { task(self) })
+	return t
+}
+
+func (s *recorder) Wait() {
+}
+
+func (s *recorder) Gosched() {
+	runtime.Gosched()
+}
+
+func (s *recorder) IsConcurrent() bool {
+	return false
+}
+
+// Count returns the number of recorded calls that were neither fired nor
+// cancelled.
+func (s *recorder) Count() int {
+	s.Lock()
+	defer s.Unlock()
+	count := 0
+	for _, c := range s.calls {
+		if !c.Fired && !c.task.IsCancelled() {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *recorder) String() string {
+	return fmt.Sprintf("Recorder{ calls = %d, tasks = %d }", s.Len(), s.Count())
+}
@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// replay
+
+type replay struct {
+	*trampoline
+	speed float64
+}
+
+// MakeReplay creates and returns a non-concurrent scheduler like MakeTrampoline
+// does, but one that plays back future tasks at the given speed. The due time
+// passed to ScheduleFuture and ScheduleFutureRecursive is divided by speed,
+// so a speed of 2 runs a recorded timeline twice as fast. A speed of zero or
+// less runs the timeline as fast as possible. All delays then collapse to
+// zero, but the tasks still run in the order of their due times, because the
+// scheduler uses a virtual clock that jumps straight to the next due task.
+func MakeReplay(speed float64) *replay {
+	if speed <= 0 {
+		return &replay{MakeTrampolineWithClock(&virtualclock{now: time.Now()}), speed}
+	}
+	return &replay{MakeTrampoline(), speed}
+}
+
+func (s *replay) scale(due time.Duration) time.Duration {
+	if s.speed <= 0 {
+		return due
+	}
+	return time.Duration(float64(due) / s.speed)
+}
+
+func (s *replay) ScheduleFuture(due time.Duration, task func()) Runner {
+	return s.trampoline.ScheduleFuture(s.scale(due), task)
+}
+
+func (s *replay) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	return s.trampoline.ScheduleFutureRecursive(s.scale(due), func(self func(time.Duration)) {
+		task(func(due time.Duration) { self(s.scale(due)) })
+	})
+}
+
+func (s *replay) String() string {
+	return fmt.Sprintf("Replay{ gid = %s, speed = %v, tasks = %d }", s.gid, s.speed, s.SafeLen())
+}
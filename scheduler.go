@@ -1,6 +1,9 @@
 package scheduler
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 // Scheduler is an interface for running tasks.
 // Scheduling of tasks is asynchronous/non-blocking.
@@ -39,6 +42,10 @@ type Scheduler interface {
 	Gosched()
 
 	// IsConcurrent returns true for a scheduler that runs tasks concurrently.
+	// Such a scheduler is also safe to call from multiple goroutines, while
+	// a scheduler that returns false must only be used from one goroutine
+	// at a time. The flag is advisory, e.g. for code that needs to decide
+	// whether its tasks require additional locking.
 	IsConcurrent() bool
 
 	// Count returns the number of currently active tasks.
@@ -51,6 +58,98 @@ type Scheduler interface {
 // Runner is an interface to a running task. It can be used to cancel the
 // running task by calling its Cancel() method.
 type Runner interface {
-	// Cancel the running task.
-	Cancel()
+	// Cancel the running task. The cause of the cancellation is set to
+	// ErrCancelled. Cancel returns true when it prevented the task from
+	// running and false when the task was already running, had already run
+	// or was already cancelled.
+	Cancel() bool
+
+	// CancelCause cancels the running task like Cancel does, but sets the
+	// cause of the cancellation to err. Passing nil sets it to ErrCancelled.
+	CancelCause(err error)
+
+	// Cause returns the cause of the cancellation, or nil when the task has
+	// not been cancelled.
+	Cause() error
+
+	// IsCancelled returns true when the task has been cancelled.
+	IsCancelled() bool
+
+	// Done returns a channel that is closed when the task has finished
+	// running or when it was cancelled.
+	Done() <-chan struct{}
 }
+
+// RecursiveRunner is implemented by the runners that are returned for
+// recursive tasks, e.g. by ScheduleRecursive and ScheduleFutureRecursive, so
+// the progress of a long running recursive task can be followed without the
+// task keeping track of it.
+type RecursiveRunner interface {
+	Runner
+
+	// Iterations returns the number of times the task called self to re-arm
+	// itself so far. On a non-concurrent scheduler it is read from a task
+	// or in between calls to Wait, on a concurrent scheduler it may be read
+	// from any goroutine.
+	Iterations() int
+}
+
+// Causes of cancellation returned by Runner.Cause.
+var (
+	// ErrCancelled is the cause of a task cancelled by calling Cancel.
+	ErrCancelled = errors.New("scheduler: task cancelled")
+
+	// ErrShutdown is the cause of a task cancelled because the scheduler
+	// was shut down.
+	ErrShutdown = errors.New("scheduler: scheduler shut down")
+
+	// ErrDropped is the cause of a task cancelled because the task it was
+	// scheduled after was dropped when its deadline passed.
+	ErrDropped = errors.New("scheduler: task dropped after deadline")
+
+	// ErrBeyondHorizon is the cause of a task that was rejected because it
+	// was scheduled further ahead than the horizon of the scheduler.
+	ErrBeyondHorizon = errors.New("scheduler: task scheduled beyond horizon")
+)
+
+// Compile time checks that the schedulers implement the Scheduler interface,
+// the clocks the Clock interface, with stoppable timers where they hold on to
+// resources, and the runners the Runner interface.
+var (
+	_ Scheduler = (*trampoline)(nil)
+	_ Scheduler = (*virtualtime)(nil)
+	_ Scheduler = (*goroutine)(nil)
+	_ Scheduler = (*eventloop)(nil)
+	_ Scheduler = (*immediate)(nil)
+	_ Scheduler = (*newthread)(nil)
+	_ Scheduler = (*throttled)(nil)
+	_ Scheduler = (*tee)(nil)
+	_ Scheduler = (*recorder)(nil)
+	_ Scheduler = (*replay)(nil)
+	_ Scheduler = (*panics)(nil)
+	_ Scheduler = (*instrumented)(nil)
+	_ Scheduler = (*deferred)(nil)
+	_ Scheduler = (*condloop)(nil)
+	_ Scheduler = (*noop)(nil)
+	_ Scheduler = (*traced)(nil)
+
+	_ Clock = realtime{}
+	_ Clock = (*virtualclock)(nil)
+	_ Clock = (*spyclock)(nil)
+	_ Clock = (*deferredclock)(nil)
+	_ Clock = (*scaledclock)(nil)
+	_ Clock = (*tickclock)(nil)
+
+	_ stoppable = realtime{}
+	_ stoppable = (*deferredclock)(nil)
+	_ stoppable = (*scaledclock)(nil)
+	_ stoppable = (*tickclock)(nil)
+
+	_ Runner = (*futuretask)(nil)
+	_ Runner = (*CompositeRunner)(nil)
+	_ Runner = (*anyrunner)(nil)
+	_ Runner = noopRunner{}
+
+	_ RecursiveRunner = (*futuretask)(nil)
+	_ RecursiveRunner = (*teerunner)(nil)
+)
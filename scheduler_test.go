@@ -1,7 +1,15 @@
 package scheduler_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
 	"time"
 
 	"github.com/reactivego/scheduler"
@@ -189,3 +197,3174 @@ func ExampleMakeGoroutine_cancel() {
 	// Output:
 	// tasks = 0
 }
+
+func ExampleMakeVirtualTime() {
+	const sec = time.Second
+
+	virtual := scheduler.MakeVirtualTime()
+	start := virtual.Now()
+	wallclock := time.Now()
+
+	virtual.ScheduleFuture(5*sec, func() {
+		fmt.Println("fired at", virtual.Since(start))
+	})
+	dropped := virtual.ScheduleFuture(7*sec, func() {
+		fmt.Println("dropped task fired")
+	})
+
+	virtual.Advance(4 * sec)
+	fmt.Println("advanced to", virtual.Since(start))
+	virtual.Advance(1 * sec)
+	fmt.Println("advanced to", virtual.Since(start))
+
+	dropped.Cancel()
+	virtual.Advance(5 * sec)
+	fmt.Println("advanced to", virtual.Since(start))
+
+	fmt.Println("tasks =", virtual.Count())
+	fmt.Println("fast =", time.Since(wallclock) < sec)
+	// Output:
+	// advanced to 4s
+	// fired at 5s
+	// advanced to 5s
+	// advanced to 10s
+	// tasks = 0
+	// fast = true
+}
+
+func ExampleMakeVirtualTime_advanceCancelledHead() {
+	const sec = time.Second
+
+	virtual := scheduler.MakeVirtualTime()
+	start := virtual.Now()
+
+	head := virtual.ScheduleFuture(3*sec, func() {
+		fmt.Println("cancelled task fired")
+	})
+	virtual.ScheduleFuture(10*sec, func() {
+		fmt.Println("fired at", virtual.Since(start))
+	})
+	head.Cancel()
+
+	virtual.Advance(5 * sec)
+	fmt.Println("advanced to", virtual.Since(start))
+	virtual.Advance(5 * sec)
+	fmt.Println("advanced to", virtual.Since(start))
+	// Output:
+	// advanced to 5s
+	// fired at 10s
+	// advanced to 10s
+}
+
+func ExampleMakeVirtualTime_wait() {
+	const ms = time.Millisecond
+
+	virtual := scheduler.MakeVirtualTime()
+	start := virtual.Now()
+
+	virtual.ScheduleFutureRecursive(100*ms, func(self func(time.Duration)) {
+		fmt.Println("tick", virtual.Since(start))
+		if virtual.Since(start) < 300*ms {
+			self(100 * ms)
+		}
+	})
+
+	virtual.Wait()
+	fmt.Println("tasks =", virtual.Count())
+	// Output:
+	// tick 100ms
+	// tick 200ms
+	// tick 300ms
+	// tasks = 0
+}
+
+// stepclock is a Clock that only advances when a timer is requested from it.
+type stepclock struct {
+	now    time.Time
+	timers []time.Duration
+}
+
+func (c *stepclock) Now() time.Time {
+	return c.now
+}
+
+func (c *stepclock) Timer(d time.Duration) <-chan time.Time {
+	c.timers = append(c.timers, d)
+	c.now = c.now.Add(d)
+	fired := make(chan time.Time, 1)
+	fired <- c.now
+	return fired
+}
+
+func ExampleMakeTrampolineWithClock() {
+	const sec = time.Second
+
+	clock := &stepclock{now: time.Date(2021, 7, 5, 12, 0, 0, 0, time.UTC)}
+	serial := scheduler.MakeTrampolineWithClock(clock)
+
+	serial.ScheduleFuture(2*sec, func() {
+		fmt.Println("2s task at", serial.Now().Format("15:04:05"))
+	})
+	serial.ScheduleFuture(1*sec, func() {
+		fmt.Println("1s task at", serial.Now().Format("15:04:05"))
+	})
+
+	serial.Wait()
+	fmt.Println("timers =", clock.timers)
+	// Output:
+	// 1s task at 12:00:01
+	// 2s task at 12:00:02
+	// timers = [1s 1s]
+}
+
+func ExampleRunner_cancel() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampoline()
+	concurrent := scheduler.MakeGoroutine()
+
+	runners := []scheduler.Runner{
+		serial.Schedule(func() { fmt.Println("serial task") }),
+		serial.ScheduleRecursive(func(self func()) { fmt.Println("serial recursive task") }),
+		concurrent.ScheduleFuture(10*ms, func() { fmt.Println("concurrent task") }),
+	}
+	for _, runner := range runners {
+		// Cancelling the same runner more than once is harmless.
+		runner.Cancel()
+		runner.Cancel()
+		runner.Cancel()
+	}
+
+	serial.Wait()
+	concurrent.Wait()
+	fmt.Println("tasks =", serial.Count()+concurrent.Count())
+	// Output:
+	// tasks = 0
+}
+
+func ExampleRunner_isCancelled() {
+	serial := scheduler.MakeTrampoline()
+
+	i := 0
+	var runner scheduler.Runner
+	runner = serial.ScheduleRecursive(func(self func()) {
+		fmt.Println(i)
+		i++
+		if i == 3 {
+			runner.Cancel()
+		}
+		if !runner.IsCancelled() {
+			self()
+		}
+	})
+
+	serial.Wait()
+	fmt.Println("cancelled =", runner.IsCancelled())
+	// Output:
+	// 0
+	// 1
+	// 2
+	// cancelled = true
+}
+
+// Tasks that are due at the same time run in the order they were scheduled.
+func ExampleMakeVirtualTime_dispatchOrder() {
+	const sec = time.Second
+
+	virtual := scheduler.MakeVirtualTime()
+
+	for i := 0; i < 3; i++ {
+		i := i
+		virtual.ScheduleFuture(sec, func() { fmt.Println("future", i) })
+		virtual.Schedule(func() { fmt.Println("immediate", i) })
+	}
+
+	virtual.Wait()
+	// Output:
+	// immediate 0
+	// immediate 1
+	// immediate 2
+	// future 0
+	// future 1
+	// future 2
+}
+
+func BenchmarkTrampoline_scheduleRecursive(b *testing.B) {
+	b.ReportAllocs()
+	serial := scheduler.MakeTrampoline()
+	i := 0
+	serial.ScheduleRecursive(func(self func()) {
+		i++
+		if i < b.N {
+			self()
+		}
+	})
+	serial.Wait()
+}
+
+func BenchmarkTrampoline_scheduleBurst(b *testing.B) {
+	b.ReportAllocs()
+	serial := scheduler.MakeTrampoline()
+	for i := 0; i < b.N; i++ {
+		serial.Schedule(func() {})
+	}
+	serial.Wait()
+}
+
+func BenchmarkTrampoline_scheduleNoCancel(b *testing.B) {
+	b.ReportAllocs()
+	serial := scheduler.MakeTrampoline()
+	task := func() {}
+	for i := 0; i < b.N; i++ {
+		serial.Schedule(task)
+		serial.Wait()
+	}
+}
+
+func BenchmarkTrampoline_scheduleDone(b *testing.B) {
+	b.ReportAllocs()
+	serial := scheduler.MakeTrampoline()
+	task := func() {}
+	for i := 0; i < b.N; i++ {
+		done := serial.Schedule(task).Done()
+		serial.Wait()
+		<-done
+	}
+}
+
+func BenchmarkTrampoline_schedule10k(b *testing.B) {
+	b.ReportAllocs()
+	serial := scheduler.MakeTrampoline()
+	tasks := make([]func(), 10000)
+	for i := range tasks {
+		tasks[i] = func() {}
+	}
+	for n := 0; n < b.N; n++ {
+		for _, task := range tasks {
+			serial.Schedule(task)
+		}
+		serial.Wait()
+	}
+}
+
+func BenchmarkTrampoline_scheduleRelease10k(b *testing.B) {
+	b.ReportAllocs()
+	serial := scheduler.MakeTrampoline()
+	tasks := make([]func(), 10000)
+	for i := range tasks {
+		tasks[i] = func() {}
+	}
+	for n := 0; n < b.N; n++ {
+		for _, task := range tasks {
+			serial.Release(serial.Schedule(task))
+		}
+		serial.Wait()
+	}
+}
+
+func BenchmarkTrampoline_scheduleBatch10k(b *testing.B) {
+	b.ReportAllocs()
+	serial := scheduler.MakeTrampoline()
+	tasks := make([]func(), 10000)
+	for i := range tasks {
+		tasks[i] = func() {}
+	}
+	for n := 0; n < b.N; n++ {
+		serial.ScheduleBatch(tasks)
+		serial.Wait()
+	}
+}
+
+func benchmarkCluster(b *testing.B, window time.Duration) {
+	b.ReportAllocs()
+	r := rand.New(rand.NewSource(1))
+	task := func() {}
+	for n := 0; n < b.N; n++ {
+		serial := scheduler.MakeTrampolineCoalesce(window)
+		for i := 0; i < 1000; i++ {
+			serial.ScheduleFuture(time.Duration(r.Int63n(int64(10*time.Millisecond))), task)
+		}
+		serial.Wait()
+	}
+}
+
+func BenchmarkTrampoline_scheduleCluster1000(b *testing.B) {
+	benchmarkCluster(b, 0)
+}
+
+func BenchmarkTrampolineCoalesce_scheduleCluster1000(b *testing.B) {
+	benchmarkCluster(b, 10*time.Millisecond)
+}
+
+func benchmarkBurst(b *testing.B, serial scheduler.Scheduler) {
+	b.ReportAllocs()
+	task := func() {}
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < 100000; i++ {
+			serial.Schedule(task)
+		}
+		serial.Wait()
+	}
+}
+
+func BenchmarkTrampoline_scheduleBurst100k(b *testing.B) {
+	benchmarkBurst(b, scheduler.MakeTrampoline())
+}
+
+func BenchmarkTrampolineCap_scheduleBurst100k(b *testing.B) {
+	benchmarkBurst(b, scheduler.MakeTrampolineCap(100000))
+}
+
+func ExampleMakeTrampolineCap() {
+	serial := scheduler.MakeTrampolineCap(1000)
+	task := func() {}
+
+	// The tasks of a burst that fits the capacity are allocated together.
+	allocs := testing.AllocsPerRun(10, func() {
+		for i := 0; i < 1000; i++ {
+			serial.Schedule(task)
+		}
+		serial.Wait()
+	})
+	fmt.Println("at most a few allocations per burst", allocs <= 2)
+	// Output:
+	// at most a few allocations per burst true
+}
+
+func ExampleMakeTrampoline_waitContext() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampoline()
+
+	serial.Schedule(func() { fmt.Println("immediate") })
+	serial.ScheduleFuture(50*ms, func() { fmt.Println("future") })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*ms)
+	defer cancel()
+	fmt.Println(serial.WaitContext(ctx))
+	fmt.Println("tasks =", serial.Count())
+
+	// A later call to Wait resumes running the remaining tasks.
+	serial.Wait()
+	fmt.Println("tasks =", serial.Count())
+	// Output:
+	// immediate
+	// context deadline exceeded
+	// tasks = 1
+	// future
+	// tasks = 0
+}
+
+func ExampleMakeTrampolineRecover() {
+	serial := scheduler.MakeTrampolineRecover(func(e interface{}) {
+		fmt.Println("recovered:", e)
+	})
+
+	serial.Schedule(func() { fmt.Println("first") })
+	serial.Schedule(func() { panic("second") })
+	serial.Schedule(func() { fmt.Println("third") })
+
+	serial.Wait()
+	fmt.Println("tasks =", serial.Count())
+	// Output:
+	// first
+	// recovered: second
+	// third
+	// tasks = 0
+}
+
+func ExampleMakeTrampoline_scheduleAt() {
+	const sec = time.Second
+
+	virtual := scheduler.MakeVirtualTime()
+	start := virtual.Now()
+
+	virtual.ScheduleAt(start.Add(2*sec), func() {
+		fmt.Println("at", virtual.Since(start))
+	})
+	virtual.Schedule(func() {
+		fmt.Println("immediate")
+	})
+	virtual.ScheduleAt(start.Add(-sec), func() {
+		fmt.Println("past")
+	})
+
+	virtual.Wait()
+	// Output:
+	// immediate
+	// past
+	// at 2s
+}
+
+func ExampleMakeTrampoline_schedulePeriodic() {
+	const ms = time.Millisecond
+
+	virtual := scheduler.MakeVirtualTime()
+	start := virtual.Now()
+
+	ticks := 0
+	var periodic scheduler.Runner
+	periodic = virtual.SchedulePeriodic(500*ms, 1000*ms, func() {
+		fmt.Println("tick", virtual.Since(start))
+		ticks++
+		if ticks == 3 {
+			periodic.Cancel()
+		}
+	})
+
+	virtual.Wait()
+	fmt.Println("tasks =", virtual.Count())
+	// Output:
+	// tick 500ms
+	// tick 1.5s
+	// tick 2.5s
+	// tasks = 0
+}
+
+func ExampleMakeTrampoline_cancelAll() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampoline()
+
+	first := serial.ScheduleFuture(10*ms, func() { fmt.Println("first") })
+	serial.ScheduleFuture(20*ms, func() { fmt.Println("second") })
+	serial.Schedule(func() { fmt.Println("third") })
+
+	first.Cancel()
+	serial.CancelAll()
+	fmt.Println("tasks =", serial.Count())
+
+	serial.Wait()
+	fmt.Println("cancelled =", first.IsCancelled())
+	// Output:
+	// tasks = 0
+	// cancelled = true
+}
+
+func ExampleMakeBoundedTrampoline() {
+	serial := scheduler.MakeBoundedTrampoline(2)
+
+	for i := 0; i < 3; i++ {
+		i := i
+		_, ok := serial.TrySchedule(func() { fmt.Println("task", i) })
+		fmt.Println("scheduled", i, ok)
+	}
+	_, ok := serial.TryScheduleFuture(time.Millisecond, func() {})
+	fmt.Println("scheduled future", ok)
+
+	serial.Wait()
+	_, ok = serial.TrySchedule(func() { fmt.Println("task after wait") })
+	fmt.Println("scheduled after wait", ok)
+	serial.Wait()
+	// Output:
+	// scheduled 0 true
+	// scheduled 1 true
+	// scheduled 2 false
+	// scheduled future false
+	// task 0
+	// task 1
+	// scheduled after wait true
+	// task after wait
+}
+
+func ExampleMakeTrampoline_pause() {
+	serial := scheduler.MakeTrampoline()
+
+	serial.Schedule(func() {
+		fmt.Println("first")
+		serial.Pause()
+	})
+	serial.Schedule(func() { fmt.Println("second") })
+	serial.Schedule(func() { fmt.Println("third") })
+
+	serial.Wait()
+	fmt.Println("paused =", serial.IsPaused(), "tasks =", serial.Count())
+
+	serial.Resume()
+	serial.Wait()
+	fmt.Println("paused =", serial.IsPaused(), "tasks =", serial.Count())
+	// Output:
+	// first
+	// paused = true tasks = 2
+	// second
+	// third
+	// paused = false tasks = 0
+}
+
+func ExampleMakeTrampoline_pauseWaiting() {
+	serial := scheduler.MakeTrampoline()
+
+	serial.ScheduleFuture(time.Hour, func() { fmt.Println("never") })
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		serial.Pause()
+	}()
+	start := time.Now()
+	serial.Wait()
+	fmt.Println("returned early =", time.Since(start) < time.Second)
+	fmt.Println("paused =", serial.IsPaused(), "tasks =", serial.Count())
+
+	serial.Resume()
+	serial.CancelAll()
+	serial.ScheduleFuture(10*time.Millisecond, func() { fmt.Println("resumed") })
+	serial.Wait()
+	// Output:
+	// returned early = true
+	// paused = true tasks = 1
+	// resumed
+}
+
+func ExampleMakeEventLoop() {
+	loop := scheduler.MakeEventLoop()
+
+	var producers sync.WaitGroup
+	gids := make(map[string]int)
+	for p := 0; p < 4; p++ {
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			for i := 0; i < 25; i++ {
+				loop.Schedule(func() {
+					// Tasks run serially, so no locking is needed here.
+					gids[scheduler.Gid()]++
+				})
+			}
+		}()
+	}
+	producers.Wait()
+
+	loop.Wait()
+	for _, count := range gids {
+		fmt.Println("tasks run on loop goroutine =", count)
+	}
+
+	loop.ScheduleFuture(time.Millisecond, func() { fmt.Println("future") })
+	loop.Shutdown()
+	fmt.Println("tasks =", loop.Count())
+	// Output:
+	// tasks run on loop goroutine = 100
+	// future
+	// tasks = 0
+}
+
+func ExampleMakeTrampoline_cancelRecursive() {
+	serial := scheduler.MakeTrampoline()
+
+	i := 0
+	var runner scheduler.Runner
+	runner = serial.ScheduleRecursive(func(self func()) {
+		i++
+		if i == 5 {
+			runner.Cancel()
+		}
+		// Calling self after the runner was cancelled does not re-arm.
+		self()
+	})
+
+	serial.Wait()
+	fmt.Println("iterations =", i)
+	fmt.Println("tasks =", serial.Count())
+	// Output:
+	// iterations = 5
+	// tasks = 0
+}
+
+func ExampleMakeGoroutine_cancelRecursive() {
+	concurrent := scheduler.MakeGoroutine()
+
+	var i int32
+	runner := concurrent.ScheduleRecursive(func(self func()) {
+		atomic.AddInt32(&i, 1)
+		self()
+	})
+	for atomic.LoadInt32(&i) < 100 {
+		runtime.Gosched()
+	}
+	runner.Cancel()
+	stopped := atomic.LoadInt32(&i)
+
+	concurrent.Wait()
+	fmt.Println("stopped within one iteration =", atomic.LoadInt32(&i)-stopped <= 1)
+	// Output:
+	// stopped within one iteration = true
+}
+
+func ExampleMakeTrampoline_elapsed() {
+	const ms = time.Millisecond
+
+	virtual := scheduler.MakeVirtualTime()
+
+	virtual.ScheduleFuture(100*ms, func() {
+		virtual.ScheduleFuture(250*ms, func() {
+			fmt.Println("elapsed in task", virtual.Elapsed())
+		})
+	})
+	virtual.Wait()
+	fmt.Println("elapsed after wait", virtual.Elapsed())
+
+	virtual.ScheduleFuture(50*ms, func() {})
+	virtual.Wait()
+	fmt.Println("elapsed after next wait", virtual.Elapsed())
+	// Output:
+	// elapsed in task 250ms
+	// elapsed after wait 250ms
+	// elapsed after next wait 0s
+}
+
+func ExampleMakeTrampoline_dump() {
+	const sec = time.Second
+
+	virtual := scheduler.MakeVirtualTime()
+
+	virtual.ScheduleFuture(30*sec, func() {})
+	virtual.Schedule(func() {}).Cancel()
+	virtual.ScheduleFuture(5*sec, func() {})
+
+	fmt.Println(strings.SplitN(virtual.Dump(), "\n", 2)[1])
+	// Output:
+	// 	0: due = 0s, cancelled = true
+	// 	1: due = 5s, cancelled = false
+	// 	2: due = 30s, cancelled = false
+}
+
+func ExampleMakeTrampoline_stats() {
+	serial := scheduler.MakeTrampoline()
+
+	serial.Schedule(func() {})
+	serial.Schedule(func() {}).Cancel()
+	i := 0
+	serial.ScheduleRecursive(func(self func()) {
+		if i++; i < 3 {
+			self()
+		}
+	})
+	serial.ScheduleFuture(time.Hour, func() {})
+
+	serial.ScheduleFuture(time.Millisecond, func() {
+		serial.CancelAll()
+	})
+
+	serial.Wait()
+	fmt.Printf("%+v\n", serial.Stats())
+	// Output:
+	// {Scheduled:7 Run:5 Cancelled:2 Dropped:0}
+}
+
+func ExampleMakeTrampolineHooked() {
+	depth := 0
+	serial := scheduler.MakeTrampolineHooked(
+		func() { depth++; fmt.Println("before, depth =", depth) },
+		func() { depth--; fmt.Println("after, depth =", depth) },
+	)
+
+	serial.Schedule(func() { fmt.Println("task") })
+
+	serial.Wait()
+	// Output:
+	// before, depth = 1
+	// task
+	// after, depth = 0
+}
+
+func ExampleMakeTrampoline_scheduleFuturePeriodic() {
+	const ms = time.Millisecond
+
+	virtual := scheduler.MakeVirtualTime()
+
+	frames := 0
+	var animation scheduler.Runner
+	animation = virtual.ScheduleFuturePeriodic(0, 16*ms, func(delta time.Duration) {
+		frames++
+		fmt.Println("frame", frames, "delta", delta)
+		if frames == 3 {
+			animation.Cancel()
+		}
+	})
+
+	virtual.Wait()
+	// Output:
+	// frame 1 delta 16ms
+	// frame 2 delta 16ms
+	// frame 3 delta 16ms
+}
+
+func ExampleMakeTrampoline_scheduleRecursiveLimit() {
+	serial := scheduler.MakeTrampoline()
+
+	i := 0
+	serial.ScheduleRecursiveLimit(3, func(self func()) {
+		fmt.Println(i)
+		i++
+		// Buggy task that always re-arms itself.
+		self()
+	}, func() {
+		fmt.Println("limit exceeded")
+	})
+
+	serial.Wait()
+	// Output:
+	// 0
+	// 1
+	// 2
+	// limit exceeded
+}
+
+func ExampleMakeTrampoline_waitE() {
+	serial := scheduler.MakeTrampoline()
+
+	serial.ScheduleE(func() error { fmt.Println("first"); return nil })
+	serial.ScheduleE(func() error { fmt.Println("second"); return errors.New("second failed") })
+	serial.ScheduleE(func() error { fmt.Println("third"); return nil })
+
+	fmt.Println("err =", serial.WaitE())
+	fmt.Println("tasks =", serial.Count())
+
+	// Continue running the remaining tasks.
+	fmt.Println("err =", serial.WaitE())
+	// Output:
+	// first
+	// second
+	// err = second failed
+	// tasks = 1
+	// third
+	// err = <nil>
+}
+
+func ExampleRunner_done() {
+	const ms = time.Millisecond
+
+	concurrent := scheduler.MakeGoroutine()
+
+	runner := concurrent.ScheduleFuture(10*ms, func() { fmt.Println("task") })
+	<-runner.Done()
+	fmt.Println("done")
+
+	cancelled := concurrent.ScheduleFuture(time.Hour, func() { fmt.Println("never") })
+	cancelled.Cancel()
+	<-cancelled.Done()
+	fmt.Println("cancelled")
+
+	serial := scheduler.MakeTrampoline()
+	i := 0
+	recursive := serial.ScheduleRecursive(func(self func()) {
+		if i++; i < 3 {
+			self()
+		}
+	})
+	serial.Wait()
+	select {
+	case <-recursive.Done():
+		fmt.Println("recursive done after", i, "iterations")
+	default:
+		fmt.Println("recursive not done")
+	}
+	// Output:
+	// task
+	// done
+	// cancelled
+	// recursive done after 3 iterations
+}
+
+// The Immediate scheduler runs tasks synchronously, so nested tasks run
+// before the task that scheduled them returns. Compare with Example_serial.
+func ExampleMakeImmediate() {
+	immediate := scheduler.MakeImmediate()
+
+	immediate.Schedule(func() {
+		fmt.Println("> outer")
+
+		immediate.Schedule(func() {
+			fmt.Println("> inner")
+
+			immediate.Schedule(func() {
+				fmt.Println("leaf")
+			})
+
+			fmt.Println("< inner")
+		})
+
+		fmt.Println("< outer")
+	})
+
+	fmt.Println("BEFORE WAIT")
+
+	immediate.Wait()
+
+	fmt.Printf("AFTER WAIT (tasks = %d)\n", immediate.Count())
+	// Output:
+	// > outer
+	// > inner
+	// leaf
+	// < inner
+	// < outer
+	// BEFORE WAIT
+	// AFTER WAIT (tasks = 0)
+}
+
+func ExampleMakeTrampoline_safeLen() {
+	serial := scheduler.MakeTrampoline()
+	for i := 0; i < 3; i++ {
+		serial.Schedule(func() {})
+	}
+
+	// Reading the length from another goroutine is race free.
+	monitor := make(chan int)
+	go func() { monitor <- serial.SafeLen() }()
+	fmt.Println("tasks =", <-monitor)
+
+	serial.Wait()
+	fmt.Println("tasks =", serial.SafeLen())
+	// Output:
+	// tasks = 3
+	// tasks = 0
+}
+
+func BenchmarkTrampoline_scheduleRecursiveWithTimers(b *testing.B) {
+	b.ReportAllocs()
+	serial := scheduler.MakeTrampoline()
+	for i := 0; i < 10000; i++ {
+		serial.ScheduleFuture(time.Hour, func() {})
+	}
+	i := 0
+	serial.ScheduleRecursive(func(self func()) {
+		i++
+		if i < b.N {
+			self()
+		} else {
+			serial.CancelAll()
+		}
+	})
+	serial.Wait()
+}
+
+func ExampleMakeTrampoline_cancelFuture() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampoline()
+
+	serial.ScheduleFuture(10*ms, func() {
+		fmt.Println("first, tasks =", serial.Count())
+	})
+	second := serial.ScheduleFuture(20*ms, func() { fmt.Println("second") })
+
+	second.Cancel()
+	serial.Wait()
+	fmt.Println("cancelled =", serial.Stats().Cancelled)
+	// Output:
+	// first, tasks = 1
+	// cancelled = 1
+}
+
+func ExampleMakeNewThread() {
+	const ms = time.Millisecond
+
+	newthread := scheduler.MakeNewThread()
+
+	i := 0
+	gids := make(map[string]bool)
+	newthread.ScheduleRecursive(func(self func()) {
+		gids[scheduler.Gid()] = true
+		if i++; i < 5 {
+			self()
+		}
+	})
+
+	n := 0
+	forever := newthread.ScheduleFutureRecursive(ms, func(self func(time.Duration)) {
+		n++
+		self(ms)
+	})
+	time.Sleep(5 * ms)
+	forever.Cancel()
+
+	newthread.Wait()
+	fmt.Println("iterations =", i, "goroutines =", len(gids))
+	fmt.Println("ran until cancelled =", n > 0, newthread.Count())
+	// Output:
+	// iterations = 5 goroutines = 1
+	// ran until cancelled = true 0
+}
+
+func ExampleMakeThrottled() {
+	const ms = time.Millisecond
+
+	virtual := scheduler.MakeVirtualTime()
+	throttled := scheduler.MakeThrottled(virtual, 10*ms)
+
+	start := throttled.Now()
+	for i := 0; i < 3; i++ {
+		i := i
+		throttled.Schedule(func() {
+			fmt.Println("task", i, "at", throttled.Since(start))
+		})
+	}
+	throttled.ScheduleFuture(50*ms, func() {
+		fmt.Println("future at", throttled.Since(start))
+	})
+	throttled.Wait()
+	// Output:
+	// task 0 at 0s
+	// task 1 at 10ms
+	// task 2 at 20ms
+	// future at 50ms
+}
+
+func ExampleMakeTrampolineWithRand() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampolineWithRand(rand.New(rand.NewSource(1)))
+
+	start := serial.Now()
+	var dues []time.Duration
+	for i := 0; i < 5; i++ {
+		serial.ScheduleFutureJitter(10*ms, 5*ms, func() {
+			dues = append(dues, serial.Since(start))
+		})
+	}
+	serial.Wait()
+	for _, due := range dues {
+		fmt.Println("within jitter =", due >= 5*ms && due < 20*ms)
+	}
+	// Output:
+	// within jitter = true
+	// within jitter = true
+	// within jitter = true
+	// within jitter = true
+	// within jitter = true
+}
+
+func ExampleMakeTee() {
+	serial := scheduler.MakeTrampoline()
+	concurrent := scheduler.MakeGoroutine()
+	tee := scheduler.MakeTee(serial, concurrent)
+
+	var count int32
+	tee.Schedule(func() { atomic.AddInt32(&count, 1) })
+	cancelled := tee.ScheduleFuture(10*time.Millisecond, func() { atomic.AddInt32(&count, 100) })
+	cancelled.Cancel()
+
+	tee.Wait()
+	<-cancelled.Done()
+	fmt.Println("count =", atomic.LoadInt32(&count))
+	fmt.Println("cancelled =", cancelled.IsCancelled())
+	// Output:
+	// count = 2
+	// cancelled = true
+}
+
+func ExampleMakeTee_isConcurrent() {
+	serial := scheduler.MakeTrampoline()
+	concurrent := scheduler.MakeGoroutine()
+
+	fmt.Println("mixed =", scheduler.MakeTee(serial, concurrent).IsConcurrent())
+	fmt.Println("concurrent =", scheduler.MakeTee(concurrent, scheduler.MakeNewThread()).IsConcurrent())
+	fmt.Println("empty =", scheduler.MakeTee().IsConcurrent())
+
+	// Decorators report the scheduler they wrap.
+	logf := func(string, ...interface{}) {}
+	for _, inner := range []scheduler.Scheduler{serial, concurrent} {
+		fmt.Println(
+			scheduler.MakeThrottled(inner, time.Millisecond).IsConcurrent(),
+			scheduler.MakeInstrumented(inner).IsConcurrent(),
+			scheduler.MakeTraced(inner, logf).IsConcurrent(),
+			scheduler.MakePanicCollector(inner).IsConcurrent())
+	}
+	fmt.Println("deferred =", scheduler.MakeDeferred().IsConcurrent())
+	// Output:
+	// mixed = false
+	// concurrent = true
+	// empty = false
+	// false false false false
+	// true true true true
+	// deferred = false
+}
+
+func ExampleMakeTrampoline_waitReentrant() {
+	serial := scheduler.MakeTrampoline()
+
+	serial.Schedule(func() {
+		fmt.Println("first")
+		serial.Schedule(func() { fmt.Println("fourth") })
+		serial.Wait()
+		fmt.Println("first done")
+	})
+	serial.Schedule(func() { fmt.Println("second") })
+	serial.Schedule(func() { fmt.Println("third") })
+
+	serial.Wait()
+	// Output:
+	// first
+	// first done
+	// second
+	// third
+	// fourth
+}
+
+func ExampleMakeTrampoline_waitVariantsReentrant() {
+	serial := scheduler.MakeTrampoline()
+
+	// The variants of Wait and Flush also return immediately when a task
+	// calls them, instead of running the queue from within the task.
+	serial.Schedule(func() {
+		fmt.Println("first")
+		serial.Schedule(func() { fmt.Println("fourth") })
+		fmt.Println("WaitE =", serial.WaitE())
+		fmt.Println("WaitAll =", serial.WaitAll())
+		fmt.Println("WaitContext =", serial.WaitContext(context.Background()))
+		fmt.Println("WaitBudget =", serial.WaitBudget(0, 0))
+		serial.Flush()
+		fmt.Println("first done")
+	})
+	serial.Schedule(func() { fmt.Println("second") })
+	serial.ScheduleE(func() error { return errors.New("third failed") })
+
+	fmt.Println("outer WaitE =", serial.WaitE())
+	serial.Flush()
+	// Output:
+	// first
+	// WaitE = <nil>
+	// WaitAll = []
+	// WaitContext = <nil>
+	// WaitBudget = 0
+	// first done
+	// second
+	// outer WaitE = third failed
+	// fourth
+}
+
+func ExampleMakeTrampoline_scheduleDeadline() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampoline()
+	serial.OnDropped(func(scheduler.Runner) { fmt.Println("dropped stale frame") })
+
+	now := serial.Now()
+	serial.Schedule(func() {
+		fmt.Println("slow task")
+		time.Sleep(20 * ms)
+	})
+	serial.ScheduleDeadline(now, now.Add(5*ms), func() { fmt.Println("frame 1") })
+	serial.ScheduleDeadline(now, now.Add(time.Second), func() { fmt.Println("frame 2") })
+
+	serial.Wait()
+	fmt.Println("dropped =", serial.Stats().Dropped)
+	// Output:
+	// slow task
+	// dropped stale frame
+	// frame 2
+	// dropped = 1
+}
+
+func ExampleCompositeRunner() {
+	serial := scheduler.MakeTrampoline()
+
+	var subscriptions scheduler.CompositeRunner
+	subscriptions.Add(serial.Schedule(func() { fmt.Println("first") }))
+	subscriptions.Add(serial.Schedule(func() { fmt.Println("second") }))
+	kept := serial.Schedule(func() { fmt.Println("third") })
+	subscriptions.Add(kept)
+	subscriptions.Remove(kept)
+
+	subscriptions.Cancel()
+	subscriptions.Cancel()
+	serial.Wait()
+	<-subscriptions.Done()
+	fmt.Println("cancelled =", subscriptions.IsCancelled())
+	// Output:
+	// third
+	// cancelled = true
+}
+
+func ExampleCompositeRunner_addAfterCancel() {
+	serial := scheduler.MakeTrampoline()
+
+	var subscriptions scheduler.CompositeRunner
+	subscriptions.Cancel()
+
+	late := serial.Schedule(func() { fmt.Println("late") })
+	subscriptions.Add(late)
+
+	serial.Wait()
+	fmt.Println("cancelled =", late.IsCancelled())
+	// Output:
+	// cancelled = true
+}
+
+func ExampleMakeTrampoline_scheduleOnDrain() {
+	serial := scheduler.MakeTrampoline()
+
+	serial.ScheduleOnDrain(func() {
+		fmt.Println("drained")
+		serial.Schedule(func() { fmt.Println("cleanup") })
+	})
+	serial.ScheduleOnDrain(func() { fmt.Println("drained again") })
+	serial.Schedule(func() { fmt.Println("task") })
+
+	serial.Wait()
+	// Output:
+	// task
+	// drained
+	// cleanup
+	// drained again
+}
+
+func ExampleMakeRecorder() {
+	const ms = time.Millisecond
+
+	recorder := scheduler.MakeRecorder()
+
+	recorder.Schedule(func() { fmt.Println("first") })
+	recorder.ScheduleFuture(10*ms, func() { fmt.Println("second") })
+	cancelled := recorder.Schedule(func() { fmt.Println("cancelled") })
+	i := 0
+	recorder.ScheduleFutureRecursive(5*ms, func(self func(time.Duration)) {
+		fmt.Println("iteration", i)
+		if i++; i < 2 {
+			self(20 * ms)
+		}
+	})
+	cancelled.Cancel()
+
+	// Run the recorded tasks in reverse order.
+	for index := recorder.Len() - 1; index >= 0; index-- {
+		recorder.Fire(index)
+	}
+	fmt.Println("fired self =", recorder.Fire(4))
+	fmt.Println("fired again =", recorder.Fire(4))
+	for _, call := range recorder.Calls() {
+		fmt.Println(call.Method, call.Due, call.Fired, call.Runner.IsCancelled())
+	}
+	// Output:
+	// iteration 0
+	// second
+	// first
+	// iteration 1
+	// fired self = true
+	// fired again = false
+	// Schedule 0s true false
+	// ScheduleFuture 10ms true false
+	// Schedule 0s false true
+	// ScheduleFutureRecursive 5ms true false
+	// self 20ms true false
+}
+
+func ExampleMakeTrampoline_scheduleContext() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampoline()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serial.ScheduleContext(ctx, func() { fmt.Println("immediate") })
+	future := serial.ScheduleFutureContext(ctx, time.Hour, func() { fmt.Println("future") })
+	serial.ScheduleFuture(5*ms, cancel)
+
+	start := time.Now()
+	serial.Wait()
+	fmt.Println("waited less than an hour =", time.Since(start) < time.Hour)
+	fmt.Println("cancelled =", future.IsCancelled())
+	// Output:
+	// immediate
+	// waited less than an hour = true
+	// cancelled = true
+}
+
+func ExampleMakeTrampoline_schedulePriority() {
+	virtual := scheduler.MakeVirtualTime()
+
+	virtual.Schedule(func() { fmt.Println("normal 1") })
+	virtual.SchedulePriority(-1, func() { fmt.Println("low") })
+	virtual.SchedulePriority(10, func() { fmt.Println("urgent") })
+	virtual.Schedule(func() { fmt.Println("normal 2") })
+	virtual.SchedulePriority(10, func() { fmt.Println("urgent 2") })
+
+	virtual.Wait()
+	// Output:
+	// urgent
+	// urgent 2
+	// normal 1
+	// normal 2
+	// low
+}
+
+func ExampleMakeTrampoline_schedulePriorityRealTime() {
+	serial := scheduler.MakeTrampoline()
+
+	// On the real-time clock every call sees a later time, but the tasks
+	// scheduled back to back still run by priority, also when scheduled by
+	// a running task. Those run after the tasks that were already due.
+	serial.Schedule(func() {
+		for _, priority := range []int{1, 5, 10} {
+			priority := priority
+			serial.SchedulePriority(priority, func() { fmt.Println("inner", priority) })
+			time.Sleep(time.Microsecond)
+		}
+	})
+	for _, priority := range []int{1, -1, 5, 0, 10} {
+		priority := priority
+		serial.SchedulePriority(priority, func() { fmt.Println("priority", priority) })
+		time.Sleep(time.Microsecond)
+	}
+	serial.Wait()
+	// Output:
+	// priority 10
+	// priority 5
+	// priority 1
+	// priority 0
+	// priority -1
+	// inner 10
+	// inner 5
+	// inner 1
+}
+
+func ExampleMakeTrampoline_flush() {
+	serial := scheduler.MakeTrampoline()
+
+	frame := 0
+	serial.ScheduleRecursive(func(self func()) {
+		fmt.Println("frame", frame)
+		if frame++; frame < 3 {
+			self()
+		}
+	})
+	serial.Schedule(func() { fmt.Println("input") })
+	serial.ScheduleFuture(time.Hour, func() { fmt.Println("later") })
+
+	for i := 0; i < 3; i++ {
+		serial.Flush()
+		fmt.Println("flushed, tasks =", serial.Count())
+	}
+	// Output:
+	// frame 0
+	// input
+	// flushed, tasks = 2
+	// frame 1
+	// flushed, tasks = 2
+	// frame 2
+	// flushed, tasks = 1
+}
+
+func ExampleMakeTrampoline_nextDue() {
+	const ms = time.Millisecond
+
+	virtual := scheduler.MakeVirtualTime()
+
+	virtual.ScheduleFuture(10*ms, func() { fmt.Println("cancelled") }).Cancel()
+	virtual.ScheduleFuture(30*ms, func() { fmt.Println("tick") })
+
+	for due, ok := virtual.NextDue(); ok; due, ok = virtual.NextDue() {
+		fmt.Println("next due in", due)
+		virtual.Advance(due)
+	}
+	// Output:
+	// next due in 30ms
+	// tick
+}
+
+func ExampleMakeTrampoline_scheduleLabeled() {
+	var label func() string
+	serial := scheduler.MakeTrampolineHooked(func() {
+		fmt.Printf("running %q\n", label())
+	}, nil)
+	label = serial.CurrentLabel
+
+	serial.ScheduleLabeled("map", func() {})
+	serial.Schedule(func() {})
+	serial.ScheduleLabeled("filter", func() {})
+	serial.Wait()
+
+	virtual := scheduler.MakeVirtualTime()
+	virtual.ScheduleLabeled("map", func() {})
+	virtual.Schedule(func() {})
+	fmt.Println(strings.SplitN(virtual.Dump(), "\n", 2)[1])
+	// Output:
+	// running "map"
+	// running ""
+	// running "filter"
+	// 	0: due = 0s, cancelled = false, label = "map"
+	// 	1: due = 0s, cancelled = false
+}
+
+func ExampleMakeTrampoline_shutdown() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampoline()
+
+	serial.Schedule(func() { fmt.Println("ready 1") })
+	serial.ScheduleFuture(10*ms, func() { fmt.Println("timer 1") })
+	serial.Schedule(func() { fmt.Println("ready 2") })
+	serial.ScheduleFuture(time.Hour, func() { fmt.Println("timer 2") })
+
+	fmt.Println("cancelled =", serial.Shutdown())
+	fmt.Println("tasks =", serial.Count())
+	// Output:
+	// ready 1
+	// ready 2
+	// cancelled = 2
+	// tasks = 0
+}
+
+func ExampleMakeTrampolineWithClock_jumpBack() {
+	const sec = time.Second
+
+	clock := &stepclock{now: time.Date(2021, 7, 5, 12, 0, 0, 0, time.UTC)}
+	serial := scheduler.MakeTrampolineWithClock(clock)
+
+	serial.ScheduleFuture(2*sec, func() {
+		fmt.Println("2s task at", serial.Now().Format("15:04:05"))
+	})
+
+	// The clock jumps back an hour while the task is pending.
+	clock.now = clock.now.Add(-time.Hour)
+
+	serial.Wait()
+	fmt.Println("timers =", clock.timers)
+	// Output:
+	// 2s task at 11:00:02
+	// timers = [2s]
+}
+
+func ExampleMakeReplay() {
+	const ms = time.Millisecond
+
+	timeline := []time.Duration{40 * ms, 0, 20 * ms}
+
+	for _, speed := range []float64{4, 0} {
+		replay := scheduler.MakeReplay(speed)
+		start := time.Now()
+		for i, offset := range timeline {
+			i := i
+			replay.ScheduleFuture(offset, func() { fmt.Println("event", i) })
+		}
+		replay.Wait()
+		fmt.Println("speed", speed, "faster than recorded =", time.Since(start) < 40*ms)
+	}
+	// Output:
+	// event 1
+	// event 2
+	// event 0
+	// speed 4 faster than recorded = true
+	// event 1
+	// event 2
+	// event 0
+	// speed 0 faster than recorded = true
+}
+
+func ExampleMakeTrampoline_scheduleTimeout() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampoline()
+
+	serial.ScheduleTimeout(50*ms, func() {}, func() { fmt.Println("fast task timed out") })
+	serial.ScheduleTimeout(5*ms, func() {
+		time.Sleep(10 * ms)
+		fmt.Println("slow task returned")
+	}, func() { fmt.Println("slow task timed out") })
+
+	serial.Wait()
+	// Output:
+	// slow task returned
+	// slow task timed out
+}
+
+func ExampleMakeGoroutine_scheduleTimeout() {
+	const ms = time.Millisecond
+
+	concurrent := scheduler.MakeGoroutine()
+
+	timedout := make(chan struct{})
+	concurrent.ScheduleTimeout(5*ms, func() {
+		<-timedout
+		fmt.Println("slow task returned")
+	}, func() {
+		fmt.Println("slow task timed out")
+		close(timedout)
+	})
+
+	concurrent.Wait()
+	// Output:
+	// slow task timed out
+	// slow task returned
+}
+
+func ExampleMakeTrampolineFair() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampolineFair()
+
+	for i := 0; i < 3; i++ {
+		i := i
+		serial.ScheduleFuture(10*ms, func() {
+			fmt.Println("timer", i)
+			serial.Schedule(func() { fmt.Println("reply", i) })
+		})
+	}
+
+	serial.Wait()
+	// Output:
+	// timer 0
+	// reply 0
+	// timer 1
+	// reply 1
+	// timer 2
+	// reply 2
+}
+
+func ExampleMakeTrampolineFair_flush() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampolineFair()
+
+	// The reply scheduled by timer 0 comes first in fair mode, but Flush
+	// skips it and still runs timer 1, which was due when Flush was called.
+	for i := 0; i < 2; i++ {
+		i := i
+		serial.ScheduleFuture(ms, func() {
+			fmt.Println("timer", i)
+			serial.Schedule(func() { fmt.Println("reply", i) })
+		})
+	}
+	time.Sleep(5 * ms)
+	serial.Flush()
+	fmt.Println("left", serial.Len())
+	serial.Flush()
+	// Output:
+	// timer 0
+	// timer 1
+	// left 2
+	// reply 0
+	// reply 1
+}
+
+func ExampleMakeSchedulerGroup() {
+	group := scheduler.MakeSchedulerGroup(4)
+	defer group.Shutdown()
+
+	var mu sync.Mutex
+	events := make(map[string][]int)
+	for i := 0; i < 100; i++ {
+		i := i
+		key := fmt.Sprint("entity-", i%5)
+		group.ScheduleKeyed(key, func() {
+			mu.Lock()
+			events[key] = append(events[key], i)
+			mu.Unlock()
+		})
+	}
+	group.Wait()
+
+	ordered := true
+	for _, list := range events {
+		for j := 1; j < len(list); j++ {
+			ordered = ordered && list[j-1] < list[j]
+		}
+	}
+	fmt.Println("entities =", len(events), "ordered =", ordered)
+	// Output:
+	// entities = 5 ordered = true
+}
+
+func ExampleMakeTrampolineLIFO() {
+	serial := scheduler.MakeTrampolineLIFO()
+
+	tree := map[string][]string{
+		"root": {"a", "b"},
+		"a":    {"a1", "a2"},
+		"b":    {"b1"},
+	}
+	var visit func(node string)
+	visit = func(node string) {
+		fmt.Println(node)
+		children := tree[node]
+		// Push the children in reverse, so the first child is visited first.
+		for i := len(children) - 1; i >= 0; i-- {
+			child := children[i]
+			serial.Schedule(func() { visit(child) })
+		}
+	}
+	serial.Schedule(func() { visit("root") })
+
+	serial.Wait()
+	// Output:
+	// root
+	// a
+	// a1
+	// a2
+	// b
+	// b1
+}
+
+func ExampleMakeTrampolineLIFO_flush() {
+	serial := scheduler.MakeTrampolineLIFO()
+
+	// The task scheduled by B comes first in LIFO mode, but Flush skips it
+	// and still runs A.
+	serial.Schedule(func() { fmt.Println("A") })
+	serial.Schedule(func() {
+		fmt.Println("B")
+		serial.Schedule(func() { fmt.Println("C") })
+	})
+	serial.Flush()
+	fmt.Println("left", serial.Len())
+	serial.Flush()
+	// Output:
+	// B
+	// A
+	// left 1
+	// C
+}
+
+func ExampleMakeTrampoline_reset() {
+	serial := scheduler.MakeTrampoline()
+
+	for _, batch := range []string{"first", "second"} {
+		batch := batch
+		serial.Schedule(func() { fmt.Println(batch, "batch") })
+		serial.ScheduleOnDrain(func() { fmt.Println(batch, "drained") })
+		leftover := serial.ScheduleFuture(time.Hour, func() {})
+
+		serial.Flush()
+		fmt.Println("stats", serial.Stats())
+		serial.Reset()
+		fmt.Println("cancelled =", leftover.IsCancelled(), "tasks =", serial.Count())
+	}
+	// Output:
+	// first batch
+	// stats {2 1 0 0}
+	// cancelled = true tasks = 0
+	// second batch
+	// stats {2 1 0 0}
+	// cancelled = true tasks = 0
+}
+
+func ExampleMakeTrampoline_scheduleAfter() {
+	serial := scheduler.MakeTrampoline()
+
+	load := serial.ScheduleFuture(time.Millisecond, func() { fmt.Println("load") })
+	parse := serial.ScheduleAfter(load, func() { fmt.Println("parse") })
+	serial.ScheduleAfter(parse, func() { fmt.Println("render") })
+	serial.Schedule(func() { fmt.Println("idle") })
+
+	fetch := serial.ScheduleFuture(time.Hour, func() { fmt.Println("fetch") })
+	store := serial.ScheduleAfter(fetch, func() { fmt.Println("store") })
+	fetch.Cancel()
+
+	serial.Wait()
+	fmt.Println("store cancelled =", store.IsCancelled())
+	// Output:
+	// idle
+	// load
+	// parse
+	// render
+	// store cancelled = true
+}
+
+func ExampleMakeTrampoline_scheduleAfterDropped() {
+	serial := scheduler.MakeVirtualTime()
+	now := serial.Now()
+
+	stale := serial.ScheduleDeadline(now.Add(2*time.Second), now.Add(time.Second), func() { fmt.Println("stale") })
+	serial.Wait()
+
+	after := serial.ScheduleAfter(stale, func() { fmt.Println("after") })
+	serial.Wait()
+	fmt.Println("after cancelled =", after.IsCancelled(), after.Cause() == scheduler.ErrDropped)
+	fmt.Println("stale cancelled =", stale.IsCancelled())
+	// Output:
+	// after cancelled = true true
+	// stale cancelled = false
+}
+
+func ExampleMakeTrampoline_scheduleWithContext() {
+	type key string
+
+	serial := scheduler.MakeTrampoline()
+
+	trace := func(ctx context.Context) {
+		fmt.Println("trace id =", ctx.Value(key("trace")))
+	}
+	ctx := context.WithValue(context.Background(), key("trace"), "a1")
+	serial.ScheduleWithContext(ctx, trace)
+
+	cancelled, cancel := context.WithCancel(context.WithValue(ctx, key("trace"), "b2"))
+	skipped := serial.ScheduleWithContext(cancelled, trace)
+	cancel()
+
+	serial.Wait()
+	fmt.Println("skipped =", skipped.IsCancelled())
+	// Output:
+	// trace id = a1
+	// skipped = true
+}
+
+func ExampleMakeTrampoline_sequence() {
+	serial := scheduler.MakeTrampoline()
+
+	ordered, next := true, 0
+	for batch := 0; batch < 4; batch++ {
+		for i := 0; i < 1<<19; i++ {
+			i := batch<<19 + i
+			serial.Schedule(func() {
+				ordered = ordered && i == next
+				next++
+			})
+		}
+		serial.Wait()
+	}
+	fmt.Println("tasks run =", next, "ordered =", ordered)
+	// Output:
+	// tasks run = 2097152 ordered = true
+}
+
+func ExampleMakeTrampoline_scope() {
+	virtual := scheduler.MakeVirtualTime()
+
+	virtual.ScheduleFuture(20*time.Millisecond, func() { fmt.Println("parent at 20ms") })
+
+	committed := virtual.BeginScope()
+	committed.ScheduleFuture(30*time.Millisecond, func() { fmt.Println("scope at 30ms") })
+	committed.Schedule(func() { fmt.Println("scope now") })
+	cancelled := committed.ScheduleFuture(10*time.Millisecond, func() { fmt.Println("scope at 10ms") })
+	virtual.Commit(committed)
+	cancelled.Cancel()
+
+	discarded := virtual.BeginScope()
+	discarded.Schedule(func() { fmt.Println("discarded") })
+	virtual.Discard(discarded)
+
+	virtual.Wait()
+	// Output:
+	// scope now
+	// parent at 20ms
+	// scope at 30ms
+}
+
+func ExampleMakeTrampoline_scopeRecursive() {
+	virtual := scheduler.MakeVirtualTime()
+
+	// A recursive task committed from a scope runs its next iterations in
+	// the parent.
+	scope := virtual.BeginScope()
+	count := 0
+	scope.ScheduleRecursive(func(self func()) {
+		count++
+		fmt.Println("iteration", count)
+		if count < 3 {
+			self()
+		}
+	})
+	virtual.Commit(scope)
+	virtual.Wait()
+	fmt.Println("scope =", scope.Len(), "parent =", virtual.Len())
+	// Output:
+	// iteration 1
+	// iteration 2
+	// iteration 3
+	// scope = 0 parent = 0
+}
+
+func ExampleMakeTrampoline_waitBudget() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampoline()
+
+	for i := 0; i < 5; i++ {
+		serial.Schedule(func() {})
+	}
+	serial.ScheduleFuture(time.Hour, func() {})
+	fmt.Println("budget of 3 tasks ran", serial.WaitBudget(3, 0))
+	fmt.Println("no budget ran", serial.WaitBudget(0, 0))
+
+	for i := 0; i < 5; i++ {
+		serial.Schedule(func() { time.Sleep(4 * ms) })
+	}
+	fmt.Println("time slice ran fewer =", serial.WaitBudget(0, 6*ms) < 5)
+	fmt.Println("tasks =", serial.Count() > 1)
+	// Output:
+	// budget of 3 tasks ran 3
+	// no budget ran 2
+	// time slice ran fewer = true
+	// tasks = true
+}
+
+func ExampleMakeTrampoline_scheduleFutureZero() {
+	serial := scheduler.MakeTrampoline()
+
+	serial.ScheduleFuture(0, func() { fmt.Println("future 0") })
+	serial.Schedule(func() { fmt.Println("immediate") })
+	serial.ScheduleFuture(-time.Second, func() { fmt.Println("future -1s") })
+	serial.ScheduleAt(serial.Now().Add(-time.Hour), func() { fmt.Println("an hour ago") })
+
+	serial.Wait()
+	// Output:
+	// future 0
+	// immediate
+	// future -1s
+	// an hour ago
+}
+
+func ExampleMakeTrampoline_tryFlush() {
+	const ms = time.Millisecond
+
+	serial := scheduler.MakeTrampoline()
+
+	serial.Schedule(func() { fmt.Println("ready 1") })
+	serial.ScheduleFuture(20*ms, func() { fmt.Println("future") })
+	serial.Schedule(func() { fmt.Println("ready 2") })
+
+	for serial.TryFlush() {
+		due, _ := serial.NextDue()
+		fmt.Println("pending, next due within 20ms =", due <= 20*ms)
+		time.Sleep(due)
+	}
+	// Output:
+	// ready 1
+	// ready 2
+	// pending, next due within 20ms = true
+	// future
+}
+
+func ExampleRunner_cause() {
+	serial := scheduler.MakeTrampoline()
+
+	cancelled := serial.Schedule(func() {})
+	cancelled.Cancel()
+
+	timeout := errors.New("request timed out")
+	caused := serial.Schedule(func() {})
+	caused.CancelCause(timeout)
+	after := serial.ScheduleAfter(caused, func() {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	contexted := serial.ScheduleContext(ctx, func() {})
+	cancel()
+
+	var group scheduler.CompositeRunner
+	group.Add(serial.Schedule(func() {}))
+	group.CancelCause(timeout)
+	late := serial.Schedule(func() {})
+	group.Add(late)
+
+	pending := serial.ScheduleFuture(time.Hour, func() {})
+	ran := serial.Schedule(func() {})
+	serial.Shutdown()
+
+	fmt.Println(cancelled.Cause())
+	fmt.Println(caused.Cause())
+	fmt.Println(after.Cause())
+	fmt.Println(contexted.Cause())
+	fmt.Println(group.Cause(), late.Cause())
+	fmt.Println(pending.Cause())
+	fmt.Println(ran.Cause())
+	// Output:
+	// scheduler: task cancelled
+	// request timed out
+	// request timed out
+	// context canceled
+	// request timed out request timed out
+	// scheduler: scheduler shut down
+	// <nil>
+}
+
+func ExampleMakeMerge() {
+	const ms = time.Millisecond
+
+	clock := &stepclock{now: time.Date(2021, 7, 5, 12, 0, 0, 0, time.UTC)}
+	audio := scheduler.MakeTrampolineWithClock(clock)
+	video := scheduler.MakeTrampolineWithClock(clock)
+
+	audio.ScheduleFuture(10*ms, func() { fmt.Println("audio 10ms") })
+	audio.ScheduleFuture(30*ms, func() { fmt.Println("audio 30ms") })
+	video.ScheduleFuture(20*ms, func() {
+		fmt.Println("video 20ms")
+		video.ScheduleFuture(5*ms, func() { fmt.Println("video 25ms") })
+	})
+	video.ScheduleFuture(40*ms, func() { fmt.Println("video 40ms") }).Cancel()
+
+	scheduler.MakeMerge(audio, video).Wait()
+	// Output:
+	// audio 10ms
+	// video 20ms
+	// video 25ms
+	// audio 30ms
+}
+
+func ExampleMakeMerge_idle() {
+	const ms = time.Millisecond
+
+	clock := &stepclock{now: time.Date(2021, 7, 5, 12, 0, 0, 0, time.UTC)}
+	low := scheduler.MakeTrampolineWithClock(clock)
+	high := scheduler.MakeTrampolineWithClock(clock)
+
+	low.ScheduleWhenIdle(func() { fmt.Println("low idle") })
+	low.Schedule(func() { fmt.Println("low") })
+	high.SchedulePriority(1, func() { fmt.Println("high") })
+	high.ScheduleFuture(10*ms, func() { fmt.Println("high 10ms") })
+
+	merged := scheduler.MakeMerge(low, high)
+	merged.Wait()
+	fmt.Println("tasks =", merged.Count())
+	// Output:
+	// high
+	// low
+	// low idle
+	// high 10ms
+	// tasks = 0
+}
+
+func ExampleMakeTrampoline_reschedule() {
+	const ms = time.Millisecond
+
+	virtual := scheduler.MakeVirtualTime()
+
+	start := virtual.Now()
+	save := virtual.ScheduleFuture(50*ms, func() {
+		fmt.Println("saved at", virtual.Since(start))
+	})
+	for _, input := range []time.Duration{20 * ms, 40 * ms, 60 * ms} {
+		virtual.ScheduleFuture(input, func() {
+			fmt.Println("input at", virtual.Since(start), "rescheduled =", virtual.Reschedule(save, 50*ms))
+		})
+	}
+
+	virtual.Wait()
+	fmt.Println("rescheduled after run =", virtual.Reschedule(save, 50*ms))
+	// Output:
+	// input at 20ms rescheduled = true
+	// input at 40ms rescheduled = true
+	// input at 60ms rescheduled = true
+	// saved at 110ms
+	// rescheduled after run = false
+}
+
+func ExampleMakeTrampoline_recursivePeers() {
+	virtual := scheduler.MakeVirtualTime()
+
+	recursive := 0
+	virtual.ScheduleRecursive(func(self func()) {
+		fmt.Println("recursive", recursive)
+		if recursive++; recursive < 3 {
+			self()
+			self()
+		}
+	})
+	virtual.Schedule(func() { fmt.Println("peer 1") })
+	virtual.Schedule(func() {
+		fmt.Println("peer 2")
+		virtual.Schedule(func() { fmt.Println("peer 3") })
+	})
+
+	virtual.Wait()
+	// Output:
+	// recursive 0
+	// peer 1
+	// peer 2
+	// recursive 1
+	// peer 3
+	// recursive 2
+}
+
+func ExampleMakePanicCollector() {
+	collector := scheduler.MakePanicCollector(scheduler.MakeTrampoline())
+
+	collector.Schedule(func() { panic("first") })
+	collector.Schedule(func() { fmt.Println("still running") })
+	collector.ScheduleFuture(time.Millisecond, func() { panic(errors.New("second")) })
+
+	collector.Wait()
+	fmt.Println(collector.Panics())
+	// Output:
+	// still running
+	// [first second]
+}
+
+func ExampleMakeTrampoline_cancelRearm() {
+	left := 0
+	for trial := 0; trial < 20; trial++ {
+		serial := scheduler.MakeTrampoline()
+
+		var iterations int32
+		runner := serial.ScheduleRecursive(func(self func()) {
+			atomic.AddInt32(&iterations, 1)
+			self()
+		})
+		go func() {
+			for atomic.LoadInt32(&iterations) < 10 {
+				runtime.Gosched()
+			}
+			runner.Cancel()
+		}()
+
+		serial.Wait()
+		left += serial.Count()
+	}
+	fmt.Println("tasks left after cancel =", left)
+	// Output:
+	// tasks left after cancel = 0
+}
+
+func ExampleMakeSpyClock() {
+	const sec = time.Second
+
+	clock := scheduler.MakeSpyClock(time.Date(2021, 7, 5, 12, 0, 0, 0, time.UTC))
+	serial := scheduler.MakeTrampolineWithClock(clock)
+
+	serial.ScheduleFuture(sec, func() {})
+	scheduled := clock.NowCalls()
+	clock.Advance(sec)
+	serial.Wait()
+
+	fmt.Println("calls to schedule =", scheduled)
+	fmt.Println("calls to wait =", clock.NowCalls()-scheduled)
+	last := clock.Calls()[clock.NowCalls()-1]
+	fmt.Println("last call at", last.Format("15:04:05"))
+	// Output:
+	// calls to schedule = 2
+	// calls to wait = 2
+	// last call at 12:00:01
+}
+
+func ExampleMakeTrampolineHorizon() {
+	serial := scheduler.MakeTrampolineHorizon(time.Hour)
+
+	serial.ScheduleFuture(365*24*time.Hour, func() {})
+	due, _ := serial.NextDue()
+	fmt.Println("clamped to horizon =", due <= time.Hour && due > 59*time.Minute)
+	serial.CancelAll()
+	// Output:
+	// clamped to horizon = true
+}
+
+func ExampleMakeTrampolineHorizonReject() {
+	serial := scheduler.MakeTrampolineHorizonReject(time.Hour, func(at time.Time) {
+		fmt.Println("rejected task due in", time.Until(at).Round(time.Hour))
+	})
+
+	runner := serial.ScheduleFuture(365*24*time.Hour, func() { fmt.Println("never") })
+	serial.ScheduleFuture(time.Millisecond, func() { fmt.Println("within horizon") })
+
+	serial.Wait()
+	fmt.Println(runner.Cause())
+	// Output:
+	// rejected task due in 8760h0m0s
+	// within horizon
+	// scheduler: task scheduled beyond horizon
+}
+
+func ExampleMakeVirtualTime_pending() {
+	serial := scheduler.MakeVirtualTime()
+
+	serial.Schedule(func() {})
+	serial.Schedule(func() {})
+	serial.ScheduleFuture(time.Second, func() {})
+	serial.ScheduleFuture(time.Minute, func() {})
+	serial.ScheduleFuture(time.Hour, func() {}).Cancel()
+
+	ready, future := serial.Pending()
+	fmt.Println("ready =", ready, "future =", future)
+
+	serial.Advance(time.Second)
+	ready, future = serial.Pending()
+	fmt.Println("ready =", ready, "future =", future)
+
+	serial.Wait()
+	ready, future = serial.Pending()
+	fmt.Println("ready =", ready, "future =", future)
+	// Output:
+	// ready = 2 future = 2
+	// ready = 0 future = 1
+	// ready = 0 future = 0
+}
+
+func ExampleMakeTrampoline_scheduleFutureRecursiveFixed() {
+	start := time.Date(2021, 7, 5, 12, 0, 0, 0, time.UTC)
+
+	// Every iteration takes 3ms to run and asks for the next one 10ms later.
+	drift := func(fixed bool) time.Duration {
+		clock := scheduler.MakeSpyClock(start)
+		serial := scheduler.MakeTrampolineWithClock(clock)
+		schedule := serial.ScheduleFutureRecursive
+		if fixed {
+			schedule = serial.ScheduleFutureRecursiveFixed
+		}
+		count := 0
+		var last time.Time
+		schedule(10*time.Millisecond, func(self func(time.Duration)) {
+			last = clock.Now()
+			clock.Advance(3 * time.Millisecond)
+			if count++; count < 100 {
+				self(10 * time.Millisecond)
+			}
+		})
+		serial.Wait()
+		return last.Sub(start) - 100*10*time.Millisecond
+	}
+	fmt.Println("drift =", drift(false))
+	fmt.Println("fixed drift =", drift(true))
+
+	// An iteration that overruns is followed by the next one immediately.
+	clock := scheduler.MakeSpyClock(start)
+	serial := scheduler.MakeTrampolineWithClock(clock)
+	count := 0
+	serial.ScheduleFutureRecursiveFixed(10*time.Millisecond, func(self func(time.Duration)) {
+		fmt.Println("tick at", clock.Now().Sub(start))
+		if count == 1 {
+			clock.Advance(25 * time.Millisecond)
+		}
+		if count++; count < 5 {
+			self(10 * time.Millisecond)
+		}
+	})
+	serial.Wait()
+	// Output:
+	// drift = 297ms
+	// fixed drift = 0s
+	// tick at 10ms
+	// tick at 20ms
+	// tick at 45ms
+	// tick at 55ms
+	// tick at 65ms
+}
+
+func ExampleMakeInstrumented() {
+	clock := scheduler.MakeSpyClock(time.Date(2021, 7, 5, 12, 0, 0, 0, time.UTC))
+	serial := scheduler.MakeInstrumented(scheduler.MakeTrampolineWithClock(clock))
+
+	// Every task takes 2ms to run, so every next task falls further behind.
+	for i := 0; i < 5; i++ {
+		serial.Schedule(func() { clock.Advance(2 * time.Millisecond) })
+	}
+	serial.Wait()
+
+	h := serial.Histogram()
+	for i, bound := range h.Bounds {
+		if h.Counts[i] > 0 {
+			fmt.Printf("<= %v: %d\n", bound, h.Counts[i])
+		}
+	}
+	fmt.Println("p20 =", h.Percentile(20))
+	fmt.Println("p50 =", h.Percentile(50))
+	fmt.Println("p99 =", h.Percentile(99))
+	// Output:
+	// <= 1µs: 1
+	// <= 10ms: 4
+	// p20 = 1µs
+	// p50 = 8ms
+	// p99 = 8ms
+}
+
+func ExampleMakeTrampoline_waitAll() {
+	serial := scheduler.MakeTrampolineRecover(func(e interface{}) {
+		fmt.Println("recovered:", e)
+	})
+
+	for i := 1; i <= 4; i++ {
+		i := i
+		serial.ScheduleE(func() error {
+			if i%2 == 0 {
+				return fmt.Errorf("task %d failed", i)
+			}
+			return nil
+		})
+	}
+	serial.Schedule(func() { panic("not reported") })
+	serial.ScheduleE(func() (err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				err = fmt.Errorf("task panicked: %v", e)
+			}
+		}()
+		panic("reported")
+	})
+
+	for _, err := range serial.WaitAll() {
+		fmt.Println(err)
+	}
+	fmt.Println(serial.WaitAll() == nil)
+	// Output:
+	// recovered: not reported
+	// task 2 failed
+	// task 4 failed
+	// task panicked: reported
+	// true
+}
+
+func ExampleMakeTrampoline_scheduleSource() {
+	serial := scheduler.MakeVirtualTime()
+
+	// Source 1 bursts four tasks and source 2 two, all due at the same time.
+	for i := 1; i <= 4; i++ {
+		i := i
+		serial.ScheduleSource(1, func() { fmt.Println("source 1 task", i) })
+	}
+	for i := 1; i <= 2; i++ {
+		i := i
+		serial.ScheduleSource(2, func() { fmt.Println("source 2 task", i) })
+	}
+	serial.Schedule(func() { fmt.Println("source 0 task") })
+
+	serial.Wait()
+	// Output:
+	// source 1 task 1
+	// source 2 task 1
+	// source 0 task
+	// source 1 task 2
+	// source 2 task 2
+	// source 1 task 3
+	// source 1 task 4
+}
+
+func ExampleMakeTrampoline_scheduleSourceRealTime() {
+	serial := scheduler.MakeTrampoline()
+
+	// On the real-time clock every call sees a later time, but the bursts
+	// scheduled back to back are still dispatched round-robin.
+	for _, source := range []int{1, 1, 1, 2, 2, 2} {
+		source := source
+		serial.ScheduleSource(source, func() { fmt.Println("source", source) })
+		time.Sleep(time.Microsecond)
+	}
+	serial.Wait()
+	// Output:
+	// source 1
+	// source 2
+	// source 1
+	// source 2
+	// source 1
+	// source 2
+}
+
+func ExampleMakeTrampoline_scheduleChild() {
+	serial := scheduler.MakeTrampoline()
+
+	root := serial.ScheduleFuture(time.Hour, func() { fmt.Println("root") })
+	left := serial.ScheduleChild(root, func() { fmt.Println("left") })
+	right := serial.ScheduleChild(root, func() { fmt.Println("right") })
+	leaf := serial.ScheduleChild(left, func() { fmt.Println("leaf") })
+	other := serial.ScheduleChild(serial.Schedule(func() {}), func() { fmt.Println("other") })
+
+	root.CancelCause(errors.New("torn down"))
+	late := serial.ScheduleChild(left, func() { fmt.Println("late") })
+
+	serial.Wait()
+	for _, r := range []scheduler.Runner{root, left, right, leaf, late, other} {
+		fmt.Println(r.IsCancelled(), r.Cause())
+	}
+	// Output:
+	// other
+	// true torn down
+	// true torn down
+	// true torn down
+	// true torn down
+	// true torn down
+	// false <nil>
+}
+
+func ExampleMakeTrampoline_onIdle() {
+	serial := scheduler.MakeTrampoline()
+	serial.OnBusy(func() { fmt.Println("busy") })
+	serial.OnIdle(func() { fmt.Println("idle") })
+
+	serial.Schedule(func() {
+		fmt.Println("task 1")
+		// The queue is empty at this point, but Wait is still running.
+		serial.Schedule(func() { fmt.Println("task 2") })
+	})
+	serial.Wait()
+
+	serial.Wait()
+
+	serial.ScheduleFuture(time.Millisecond, func() { fmt.Println("task 3") })
+	serial.Wait()
+	// Output:
+	// busy
+	// task 1
+	// task 2
+	// idle
+	// busy
+	// task 3
+	// idle
+}
+
+func ExampleMakeTrampoline_onIdleRun() {
+	serial := scheduler.MakeTrampoline()
+	busy, idle := 0, 0
+	serial.OnBusy(func() { busy++ })
+	serial.OnIdle(func() { idle++ })
+	ctx := context.Background()
+
+	serial.Schedule(func() { fmt.Println("cycle 1") })
+	serial.Run(ctx)
+	serial.Schedule(func() { fmt.Println("cycle 2") })
+	serial.Run(ctx)
+	fmt.Println("busy =", busy, "idle =", idle)
+
+	serial.Schedule(func() { fmt.Println("cycle 3") })
+	serial.ScheduleOnDrain(func() {
+		fmt.Println("drained")
+		serial.Schedule(func() { fmt.Println("after drain") })
+	})
+	serial.WaitContext(ctx)
+	fmt.Println("busy =", busy, "idle =", idle)
+
+	serial.Schedule(func() { fmt.Println("cycle 4") })
+	serial.Flush()
+	serial.Schedule(func() { fmt.Println("cycle 5") })
+	serial.WaitBudget(0, 0)
+	fmt.Println("busy =", busy, "idle =", idle)
+
+	limited := scheduler.MakeTrampolineMaxDrain(3, func(count int) {
+		fmt.Println("livelock after", count)
+	})
+	limited.ScheduleRecursiveLimit(5, func(self func()) { self() }, nil)
+	limited.Run(ctx)
+	// Output:
+	// cycle 1
+	// cycle 2
+	// busy = 2 idle = 2
+	// cycle 3
+	// drained
+	// after drain
+	// busy = 3 idle = 3
+	// cycle 4
+	// cycle 5
+	// busy = 5 idle = 5
+	// livelock after 4
+}
+
+func ExampleMakeTrampoline_scheduleUnique() {
+	serial := scheduler.MakeVirtualTime()
+
+	// Ten rapid keystrokes collapse into a single search for the last one.
+	runners := map[scheduler.Runner]bool{}
+	for _, text := range []string{"r", "re", "rea", "reac", "react", "reacti", "reactiv", "reactive", "reactiveg", "reactivego"} {
+		text := text
+		runners[serial.ScheduleUnique("search", 100*time.Millisecond, func() { fmt.Println("search", text) })] = true
+		serial.Advance(10 * time.Millisecond)
+	}
+	serial.ScheduleUnique("save", 0, func() { fmt.Println("save") })
+	serial.Wait()
+	fmt.Println("runners =", len(runners))
+
+	// A key that was cancelled or has run can be scheduled again.
+	serial.ScheduleUnique("search", time.Second, func() { fmt.Println("cancelled") }).Cancel()
+	serial.ScheduleUnique("search", time.Second, func() { fmt.Println("search again") })
+	serial.Wait()
+	// Output:
+	// save
+	// search reactivego
+	// runners = 1
+	// search again
+}
+
+func ExampleMakeTrampoline_run() {
+	serial := scheduler.MakeTrampoline()
+
+	serial.Schedule(func() {
+		fmt.Println("task 1")
+		fmt.Println("nested run =", serial.Run(context.Background()))
+	})
+	serial.Schedule(func() { fmt.Println("task 2") })
+	fmt.Println("run =", serial.Run(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serial.ScheduleFuture(time.Hour, func() { fmt.Println("never") })
+	serial.ScheduleFuture(10*time.Millisecond, cancel)
+	fmt.Println("run =", serial.Run(ctx))
+	fmt.Println("left =", serial.Count())
+	// Output:
+	// task 1
+	// nested run = <nil>
+	// task 2
+	// run = <nil>
+	// run = context canceled
+	// left = 1
+}
+
+func ExampleMakeTrampolineMaxDrain() {
+	var serial interface {
+		scheduler.Scheduler
+		CancelAll()
+	}
+	serial = scheduler.MakeTrampolineMaxDrain(1000, func(count int) {
+		fmt.Println("suspected livelock after", count, "tasks")
+		serial.CancelAll()
+	})
+
+	// A batch of tasks within the limit does not trigger it.
+	for i := 0; i < 1000; i++ {
+		serial.Schedule(func() {})
+	}
+	serial.Wait()
+
+	// A recursive task that re-arms itself without delay does.
+	serial.ScheduleRecursive(func(self func()) { self() })
+	serial.Wait()
+
+	defer func() { fmt.Println("panic:", recover()) }()
+	unguarded := scheduler.MakeTrampolineMaxDrain(10, nil)
+	unguarded.ScheduleRecursive(func(self func()) { self() })
+	unguarded.Wait()
+	// Output:
+	// suspected livelock after 1001 tasks
+	// panic: scheduler: suspected livelock, Wait dispatched 11 tasks without the queue becoming empty
+}
+
+func ExampleMakeVirtualTime_snapshot() {
+	funcs := map[string]func(){
+		"tick":  func() { fmt.Println("tick") },
+		"flush": func() { fmt.Println("flush") },
+	}
+
+	sim := scheduler.MakeVirtualTime()
+	sim.ScheduleLabeled("flush", funcs["flush"])
+	sim.ScheduleFuture(time.Minute, func() {})
+	sim.ScheduleFuture(time.Hour, func() {}).Cancel()
+	checkpoint := sim.Snapshot()
+	sim.CancelAll()
+
+	for _, info := range checkpoint {
+		fmt.Printf("%+v\n", info)
+	}
+
+	// Tasks scheduled with ScheduleFuture have an empty label, so restoring
+	// the checkpoint needs a function for it as well.
+	funcs[""] = funcs["tick"]
+	resumed := scheduler.MakeVirtualTime()
+	resumed.Restore(checkpoint, funcs)
+	resumed.Wait()
+	// Output:
+	// {Offset:0s Label:flush Priority:0 Cancelled:false Idle:false}
+	// {Offset:1m0s Label: Priority:0 Cancelled:false Idle:false}
+	// {Offset:1h0m0s Label: Priority:0 Cancelled:true Idle:false}
+	// flush
+	// tick
+}
+
+func ExampleMakeVirtualTime_snapshotIdle() {
+	funcs := map[string]func(){
+		"":     func() { fmt.Println("idle") },
+		"busy": func() { fmt.Println("busy") },
+	}
+
+	sim := scheduler.MakeVirtualTime()
+	sim.ScheduleWhenIdle(funcs[""])
+	sim.ScheduleLabeled("busy", funcs["busy"])
+	checkpoint := sim.Snapshot()
+	for _, info := range checkpoint {
+		fmt.Printf("%q idle = %v\n", info.Label, info.Idle)
+	}
+
+	// Idle tasks stay idle when restored and when migrated.
+	resumed := scheduler.MakeVirtualTime()
+	resumed.Restore(checkpoint, funcs)
+	resumed.Wait()
+
+	staging := scheduler.MakeTrampoline()
+	staging.ScheduleWhenIdle(funcs[""])
+	staging.Schedule(funcs["busy"])
+	serial := scheduler.MakeTrampoline()
+	staging.MigrateInto(serial)
+	serial.Wait()
+	// Output:
+	// "busy" idle = false
+	// "" idle = true
+	// busy
+	// idle
+	// busy
+	// idle
+}
+
+func ExampleMakeTrampolineGuarded() {
+	serial := scheduler.MakeTrampolineGuarded()
+	serial.Schedule(func() { fmt.Println("same goroutine") })
+	serial.Wait()
+
+	misuse := make(chan interface{})
+	go func() {
+		defer func() { misuse <- recover() }()
+		serial.Schedule(func() {})
+	}()
+	fmt.Println(strings.Contains(fmt.Sprint(<-misuse), "used from goroutine"))
+	// Output:
+	// same goroutine
+	// true
+}
+
+func ExampleMakeTrampoline_scheduleBatch() {
+	serial := scheduler.MakeTrampoline()
+
+	var tasks []func()
+	for i := 1; i <= 3; i++ {
+		i := i
+		tasks = append(tasks, func() { fmt.Println("task", i) })
+	}
+	runners := serial.ScheduleBatch(tasks)
+	runners[1].Cancel()
+
+	serial.Wait()
+	fmt.Println(len(runners), runners[1].IsCancelled())
+	// Output:
+	// task 1
+	// task 3
+	// 3 true
+}
+
+func ExampleMakeDeferred() {
+	pipeline := scheduler.MakeDeferred()
+
+	var started time.Time
+	pipeline.ScheduleFuture(50*time.Millisecond, func() {
+		fmt.Println("future task, relative to start =", time.Since(started) >= 50*time.Millisecond)
+		pipeline.Schedule(func() { fmt.Println("scheduled after start") })
+	})
+	pipeline.Schedule(func() { fmt.Println("first task") })
+	pipeline.Schedule(func() { fmt.Println("second task") })
+
+	pipeline.Wait()
+	fmt.Println("started =", pipeline.IsStarted(), "tasks =", pipeline.Count())
+
+	time.Sleep(100 * time.Millisecond)
+	started = time.Now()
+	pipeline.Start()
+	// Output:
+	// started = false tasks = 3
+	// first task
+	// second task
+	// future task, relative to start = true
+	// scheduled after start
+}
+
+func ExampleMakeDeferred_reset() {
+	pipeline := scheduler.MakeDeferred()
+	pipeline.Schedule(func() { fmt.Println("first run") })
+	pipeline.Start()
+
+	// After Reset the scheduler waits for Start again.
+	pipeline.Reset()
+	pipeline.Schedule(func() { fmt.Println("second run") })
+	pipeline.Wait()
+	fmt.Println("started =", pipeline.IsStarted(), "tasks =", pipeline.Count())
+	pipeline.Start()
+	// Output:
+	// first run
+	// started = false tasks = 1
+	// second run
+}
+
+func ExampleRunner_cancelResult() {
+	serial := scheduler.MakeTrampoline()
+
+	before := serial.Schedule(func() { fmt.Println("never") })
+	fmt.Println("cancel before run =", before.Cancel())
+	fmt.Println("cancel again =", before.Cancel())
+
+	after := serial.Schedule(func() { fmt.Println("ran") })
+	var running scheduler.Runner
+	running = serial.Schedule(func() {
+		fmt.Println("cancel while running =", running.Cancel())
+	})
+	serial.Wait()
+	fmt.Println("cancel after run =", after.Cancel(), after.IsCancelled(), after.Cause())
+
+	count := 0
+	var recursive scheduler.Runner
+	recursive = serial.ScheduleRecursive(func(self func()) {
+		count++
+		self()
+		if count == 3 {
+			fmt.Println("cancel during iteration =", recursive.Cancel())
+		}
+	})
+	serial.Wait()
+	fmt.Println("iterations =", count)
+
+	count = 0
+	recursive = serial.ScheduleRecursive(func(self func()) {
+		count++
+		self()
+	})
+	serial.Schedule(func() {
+		fmt.Println("cancel between iterations =", recursive.Cancel())
+	})
+	serial.Wait()
+	fmt.Println("iterations =", count)
+	// Output:
+	// cancel before run = true
+	// cancel again = false
+	// ran
+	// cancel while running = false
+	// cancel after run = false false <nil>
+	// cancel during iteration = false
+	// iterations = 3
+	// cancel between iterations = true
+	// iterations = 1
+}
+
+func ExampleMakeCondLoop() {
+	loop := scheduler.MakeCondLoop()
+
+	start := time.Now()
+	later := loop.ScheduleFuture(time.Hour, func() { fmt.Println("never") })
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		// Wakes up the idle loop, which runs this task right away instead
+		// of sleeping on until the task that is due in an hour.
+		loop.Schedule(func() {
+			fmt.Println("scheduled from another goroutine")
+			later.Cancel()
+		})
+	}()
+	loop.Wait()
+	fmt.Println("woken up early =", time.Since(start) < time.Minute)
+	fmt.Println(loop.Count())
+	// Output:
+	// scheduled from another goroutine
+	// woken up early = true
+	// 0
+}
+
+func ExampleMakeTrampolineTimeScale() {
+	serial := scheduler.MakeTrampolineTimeScale(1)
+
+	start := time.Now()
+	serial.ScheduleFuture(time.Second, func() {
+		fmt.Println("due after 1s of scaled time")
+	})
+	go func() {
+		// Stop time for a moment while the task is pending, then speed up.
+		time.Sleep(10 * time.Millisecond)
+		serial.SetTimeScale(0)
+		time.Sleep(50 * time.Millisecond)
+		serial.SetTimeScale(100)
+	}()
+	serial.Wait()
+	elapsed := time.Since(start)
+	fmt.Println("paused for a while =", elapsed >= 60*time.Millisecond)
+	fmt.Println("sped up =", elapsed < 500*time.Millisecond)
+	// Output:
+	// due after 1s of scaled time
+	// paused for a while = true
+	// sped up = true
+}
+
+func ExampleMakeTrampolineTimeScale_abandonedWaits() {
+	serial := scheduler.MakeTrampolineTimeScale(0)
+	serial.ScheduleFuture(time.Second, func() { fmt.Println("never") })
+
+	// With time stopped the task never becomes due, so every wait is given
+	// up. Giving up a wait releases the goroutine of its timer.
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		serial.WaitContext(ctx)
+		cancel()
+	}
+	time.Sleep(10 * time.Millisecond)
+	fmt.Println("timers released =", runtime.NumGoroutine()-before < 10)
+	// Output:
+	// timers released = true
+}
+
+func ExampleMakeTrampoline_migrateInto() {
+	clock := scheduler.MakeSpyClock(time.Date(2021, 7, 5, 12, 0, 0, 0, time.UTC))
+	staging := scheduler.MakeTrampolineWithClock(clock)
+	main := scheduler.MakeTrampolineWithClock(clock)
+
+	main.ScheduleFuture(2*time.Second, func() { fmt.Println("main 2s") })
+	staging.ScheduleFuture(3*time.Second, func() { fmt.Println("staged 3s") })
+	staging.ScheduleFuture(time.Second, func() { fmt.Println("staged 1s") })
+	cancel := staging.ScheduleFuture(time.Second, func() { fmt.Println("never") })
+	staging.Schedule(func() { fmt.Println("staged now") })
+
+	staging.MigrateInto(main)
+	fmt.Println("staging =", staging.Count(), "main =", main.Count())
+
+	fmt.Println("cancelled =", cancel.Cancel())
+	main.Wait()
+	// Output:
+	// staging = 0 main = 5
+	// cancelled = true
+	// staged now
+	// staged 1s
+	// main 2s
+	// staged 3s
+}
+
+func ExampleMakeTrampoline_migrateIntoRecursive() {
+	clock := scheduler.MakeSpyClock(time.Date(2021, 7, 5, 12, 0, 0, 0, time.UTC))
+	staging := scheduler.MakeTrampolineWithClock(clock)
+	main := scheduler.MakeTrampolineWithClock(clock)
+
+	// Migrated tasks keep running in main, also when they re-arm themselves
+	// or report an error.
+	count := 0
+	staging.ScheduleFutureRecursive(time.Second, func(self func(time.Duration)) {
+		count++
+		fmt.Println("iteration", count)
+		if count < 3 {
+			self(time.Second)
+		}
+	})
+	staging.ScheduleE(func() error { return errors.New("failed") })
+	staging.MigrateInto(main)
+
+	fmt.Println("error =", main.WaitE())
+	main.Wait()
+	fmt.Println("staging =", staging.Count(), "main =", main.Count())
+	// Output:
+	// error = failed
+	// iteration 1
+	// iteration 2
+	// iteration 3
+	// staging = 0 main = 0
+}
+
+// chain is a stand-in for a chain of reactive operators that schedules the
+// delivery of every value it produces.
+func chain(s scheduler.Scheduler, n int, observe func(int)) {
+	for i := 0; i < n; i++ {
+		if v := i * i; v%2 == 0 {
+			s.Schedule(func() { observe(v) })
+		}
+	}
+}
+
+func BenchmarkNoop_chain(b *testing.B) {
+	b.ReportAllocs()
+	noop := scheduler.MakeNoop()
+	for i := 0; i < b.N; i++ {
+		chain(noop, 100, func(int) {})
+	}
+}
+
+func ExampleMakeNoop() {
+	// Run the operator chain without the cost of running its tasks, see
+	// BenchmarkNoop_chain.
+	noop := scheduler.MakeNoop()
+	ran := false
+	chain(noop, 100, func(int) { ran = true })
+	runner := noop.ScheduleRecursive(func(self func()) { ran = true })
+	noop.ScheduleFuture(time.Millisecond, func() { ran = true })
+	noop.Wait()
+
+	<-runner.Done()
+	fmt.Println("ran =", ran, "tasks =", noop.Count())
+	fmt.Println("cancel =", runner.Cancel(), "cancelled =", runner.IsCancelled())
+	// Output:
+	// ran = false tasks = 0
+	// cancel = false cancelled = false
+}
+
+func ExampleMakeTrampoline_scheduleAtLeast() {
+	serial := scheduler.MakeVirtualTime()
+	start := serial.Now()
+
+	expired := start.Add(-time.Hour)
+	serial.ScheduleAtLeast(expired, 5*time.Second, func() {
+		fmt.Println("expired entry after", serial.Since(start))
+	})
+	serial.ScheduleAtLeast(start.Add(time.Minute), 5*time.Second, func() {
+		fmt.Println("future entry after", serial.Since(start))
+	})
+	serial.Wait()
+	// Output:
+	// expired entry after 5s
+	// future entry after 1m0s
+}
+
+func ExampleMakeTrampoline_iterations() {
+	serial := scheduler.MakeTrampoline()
+
+	chunks := 0
+	runner := serial.ScheduleRecursive(func(self func()) {
+		if chunks++; chunks < 10 {
+			self()
+		}
+	})
+	progress := runner.(scheduler.RecursiveRunner)
+	fmt.Println("before =", progress.Iterations())
+	serial.Schedule(func() { fmt.Println("during =", progress.Iterations()) })
+	serial.Wait()
+	fmt.Println("after =", progress.Iterations())
+
+	future := serial.ScheduleFutureRecursive(time.Millisecond, func(self func(time.Duration)) {
+		if chunks--; chunks > 5 {
+			self(time.Millisecond)
+		}
+	})
+	serial.Wait()
+	fmt.Println("future =", future.(scheduler.RecursiveRunner).Iterations())
+	// Output:
+	// before = 0
+	// during = 1
+	// after = 9
+	// future = 4
+}
+
+func ExampleRecursiveRunner_decorators() {
+	logf := func(string, ...interface{}) {}
+	decorators := map[string]func(scheduler.Scheduler) scheduler.Scheduler{
+		"throttled":    func(s scheduler.Scheduler) scheduler.Scheduler { return scheduler.MakeThrottled(s, time.Millisecond) },
+		"instrumented": func(s scheduler.Scheduler) scheduler.Scheduler { return scheduler.MakeInstrumented(s) },
+		"traced":       func(s scheduler.Scheduler) scheduler.Scheduler { return scheduler.MakeTraced(s, logf) },
+		"panics":       func(s scheduler.Scheduler) scheduler.Scheduler { return scheduler.MakePanicCollector(s) },
+		"tee": func(s scheduler.Scheduler) scheduler.Scheduler {
+			return scheduler.MakeTee(s, scheduler.MakeVirtualTime())
+		},
+	}
+	for _, name := range []string{"throttled", "instrumented", "traced", "panics", "tee"} {
+		serial := decorators[name](scheduler.MakeVirtualTime())
+		count, countFuture := 0, 0
+		runner := serial.ScheduleRecursive(func(self func()) {
+			if count++; count%3 != 0 {
+				self()
+			}
+		})
+		future := serial.ScheduleFutureRecursive(time.Millisecond, func(self func(time.Duration)) {
+			if countFuture++; countFuture%3 != 0 {
+				self(time.Millisecond)
+			}
+		})
+		serial.Wait()
+		fmt.Println(name, runner.(scheduler.RecursiveRunner).Iterations(), future.(scheduler.RecursiveRunner).Iterations())
+	}
+
+	recorder := scheduler.MakeRecorder()
+	runner := recorder.ScheduleRecursive(func(self func()) { self() })
+	future := recorder.ScheduleFutureRecursive(time.Second, func(self func(time.Duration)) { self(time.Second) })
+	for i := 0; i < 4; i++ {
+		recorder.Fire(i)
+	}
+	fmt.Println("recorder", runner.(scheduler.RecursiveRunner).Iterations(), future.(scheduler.RecursiveRunner).Iterations())
+	// Output:
+	// throttled 2 2
+	// instrumented 2 2
+	// traced 2 2
+	// panics 2 2
+	// tee 4 4
+	// recorder 2 2
+}
+
+// jitterclock is a Clock that jumps back and forth around the real time on
+// every call to Now, like a wall clock that is being adjusted.
+type jitterclock struct {
+	rand *rand.Rand
+}
+
+func (c jitterclock) Now() time.Time {
+	return time.Now().Add(time.Duration(c.rand.Int63n(int64(time.Millisecond))) - time.Millisecond/2)
+}
+
+func (c jitterclock) Timer(d time.Duration) <-chan time.Time {
+	return time.NewTimer(d).C
+}
+
+func ExampleMakeTrampoline_scheduleFutureZeroOrder() {
+	for _, clock := range []scheduler.Clock{nil, jitterclock{rand.New(rand.NewSource(1))}} {
+		serial := scheduler.MakeTrampoline()
+		if clock != nil {
+			serial = scheduler.MakeTrampolineWithClock(clock)
+		}
+		var order []int
+		for i := 0; i < 10000; i++ {
+			i := i
+			task := func() { order = append(order, i) }
+			if i%3 == 0 {
+				serial.Schedule(task)
+			} else {
+				serial.ScheduleFuture(0, task)
+			}
+		}
+		serial.Wait()
+		fifo := len(order) == 10000
+		for i := range order {
+			fifo = fifo && order[i] == i
+		}
+		fmt.Println("fifo =", fifo)
+	}
+	// Output:
+	// fifo = true
+	// fifo = true
+}
+
+func ExampleMakeTraced() {
+	logf := func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+	}
+	serial := scheduler.MakeTraced(scheduler.MakeVirtualTime(), logf)
+
+	// A small chain: a source emitting two values that are each mapped by a
+	// downstream task.
+	i := 0
+	serial.ScheduleFutureRecursive(time.Second, func(self func(time.Duration)) {
+		i++
+		v := i
+		serial.Schedule(func() { fmt.Println("value", v*10) })
+		if i < 2 {
+			self(time.Second)
+		}
+	})
+	serial.ScheduleFuture(time.Hour, func() {}).Cancel()
+	serial.Wait()
+	// Output:
+	// task 1: ScheduleFutureRecursive due = 1s
+	// task 2: ScheduleFuture due = 1h0m0s
+	// task 1: start
+	// task 3: Schedule
+	// task 1: self due = 1s
+	// task 1: finish
+	// task 3: start
+	// value 10
+	// task 3: finish
+	// task 1: start
+	// task 4: Schedule
+	// task 1: finish
+	// task 4: start
+	// value 20
+	// task 4: finish
+}
+
+func ExampleMakeTrampoline_yield() {
+	serial := scheduler.MakeTrampoline()
+
+	// A long task processes its work in chunks, yielding after every chunk.
+	var process func(chunk int)
+	process = func(chunk int) {
+		fmt.Println("chunk", chunk)
+		if chunk < 3 {
+			serial.Yield(func() { process(chunk + 1) })
+		}
+	}
+	serial.Schedule(func() { process(1) })
+	serial.Schedule(func() { fmt.Println("peer 1") })
+	serial.Schedule(func() { fmt.Println("peer 2") })
+
+	// Cancelling a task also cancels the rest it yielded.
+	cancel := serial.ScheduleFuture(time.Millisecond, func() {
+		fmt.Println("first half")
+		serial.Yield(func() { fmt.Println("never") })
+	})
+	serial.ScheduleFuture(time.Millisecond, func() { cancel.Cancel() })
+	serial.Wait()
+	// Output:
+	// chunk 1
+	// peer 1
+	// peer 2
+	// chunk 2
+	// chunk 3
+	// first half
+}
+
+func ExampleMakeTrampoline_yieldChain() {
+	serial := scheduler.MakeTrampoline()
+
+	// Every yield continues the same task, so a long chain of yields does
+	// not pile up memory while its runner is held.
+	var step func(n int)
+	step = func(n int) {
+		if n < 100000 {
+			serial.Yield(func() { step(n + 1) })
+		} else {
+			fmt.Println("steps", n)
+		}
+	}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	root := serial.Schedule(func() { step(1) })
+	serial.Wait()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	<-root.Done()
+	fmt.Println("retained less than 1MB =", int64(after.HeapAlloc)-int64(before.HeapAlloc) < 1<<20)
+	// Output:
+	// steps 100000
+	// retained less than 1MB = true
+}
+
+func ExampleMakeTrampolineCoalesce() {
+	const ms = time.Millisecond
+	serial := scheduler.MakeTrampolineCoalesce(100 * ms)
+
+	start := time.Now()
+	for _, due := range []time.Duration{50 * ms, 70 * ms, 90 * ms, 300 * ms} {
+		due := due
+		serial.ScheduleFuture(due, func() {
+			fmt.Printf("%v task early = %v\n", due, time.Since(start) < due)
+		})
+	}
+	serial.Wait()
+	// Output:
+	// 50ms task early = false
+	// 70ms task early = true
+	// 90ms task early = true
+	// 300ms task early = false
+}
+
+func ExampleMakeTrampoline_scheduleFutureRecursiveContext() {
+	serial := scheduler.MakeTrampoline()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	polls := 0
+	poller := serial.ScheduleFutureRecursiveContext(ctx, 0, func(ctx context.Context, self func(time.Duration)) {
+		polls++
+		fmt.Println("poll", polls)
+		if polls < 3 {
+			self(time.Millisecond)
+			return
+		}
+		// Shut down while the next poll is pending.
+		serial.ScheduleFuture(5*time.Millisecond, cancel)
+		self(time.Hour)
+	})
+
+	start := time.Now()
+	serial.Wait()
+	fmt.Println("prompt =", time.Since(start) < time.Minute)
+	fmt.Println(poller.Cause())
+
+	// A done context stops the loop from re-arming at all.
+	serial.ScheduleFutureRecursiveContext(ctx, 0, func(ctx context.Context, self func(time.Duration)) {
+		fmt.Println("never")
+	})
+	serial.Wait()
+	// Output:
+	// poll 1
+	// poll 2
+	// poll 3
+	// prompt = true
+	// context canceled
+}
+
+func ExampleMakeVirtualTime_scheduleWhenIdle() {
+	serial := scheduler.MakeVirtualTime()
+
+	// Idle work is scheduled first, but waits for the bursts to subside.
+	serial.ScheduleWhenIdle(func() { fmt.Println("compact 1") })
+	burst := func(name string) {
+		for i := 1; i <= 2; i++ {
+			i := i
+			serial.Schedule(func() {
+				fmt.Println(name, i)
+				serial.Schedule(func() { fmt.Println(name, i, "follow-up") })
+			})
+		}
+	}
+	burst("burst A")
+	serial.ScheduleFuture(10*time.Millisecond, func() {
+		serial.ScheduleWhenIdle(func() { fmt.Println("compact 2") })
+		burst("burst B")
+	})
+	serial.Wait()
+	// Output:
+	// burst A 1
+	// burst A 2
+	// burst A 1 follow-up
+	// burst A 2 follow-up
+	// compact 1
+	// burst B 1
+	// burst B 2
+	// burst B 1 follow-up
+	// burst B 2 follow-up
+	// compact 2
+}
+
+func ExampleMakeChannelDriven() {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ticks := make(chan time.Time, 10)
+	for _, ms := range []int{0, 5, 10, 15, 30} {
+		ticks <- start.Add(time.Duration(ms) * time.Millisecond)
+	}
+	close(ticks)
+	serial := scheduler.MakeChannelDriven(ticks)
+
+	at := func(name string) func() {
+		return func() { fmt.Println(name, "at", serial.Since(start)) }
+	}
+	serial.Schedule(at("now"))
+	serial.ScheduleFuture(4*time.Millisecond, at("4ms"))
+	serial.ScheduleFuture(10*time.Millisecond, at("10ms"))
+	serial.ScheduleFuture(12*time.Millisecond, at("12ms"))
+	serial.ScheduleFuture(20*time.Millisecond, at("20ms"))
+	cancel := serial.ScheduleFuture(25*time.Millisecond, at("25ms"))
+	serial.ScheduleFuture(50*time.Millisecond, at("50ms"))
+	cancel.Cancel()
+	serial.Wait()
+	fmt.Println("left", serial.Count())
+	// Output:
+	// now at 0s
+	// 4ms at 5ms
+	// 10ms at 10ms
+	// 12ms at 15ms
+	// 20ms at 30ms
+	// left 1
+}
+
+func ExampleMakeChannelDriven_cancel() {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ticks := make(chan time.Time, 1)
+	ticks <- start
+	serial := scheduler.MakeChannelDriven(ticks)
+
+	// The sequencer stays quiet, but waiting still ends when the context is
+	// done or when the task that is waited for is cancelled.
+	task := serial.ScheduleFuture(time.Second, func() { fmt.Println("never") })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fmt.Println(serial.WaitContext(ctx))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		task.Cancel()
+	}()
+	serial.Wait()
+	fmt.Println("left", serial.Count(), "at", serial.Since(start))
+	// Output:
+	// context deadline exceeded
+	// left 0 at 0s
+}
+
+func ExampleWhenAll() {
+	serial := scheduler.MakeTrampoline()
+
+	all := scheduler.WhenAll(
+		serial.Schedule(func() { fmt.Println("task 1") }),
+		serial.ScheduleFuture(2*time.Millisecond, func() { fmt.Println("task 2") }),
+		serial.ScheduleFuture(time.Millisecond, func() { fmt.Println("task 3") }),
+	)
+	select {
+	case <-all.Done():
+		fmt.Println("done too early")
+	default:
+	}
+	serial.Wait()
+	<-all.Done()
+	fmt.Println("all done")
+
+	// Cancelling the group cancels every task in it.
+	all = scheduler.WhenAll(
+		serial.ScheduleFuture(time.Millisecond, func() { fmt.Println("never 1") }),
+		serial.ScheduleFuture(time.Millisecond, func() { fmt.Println("never 2") }),
+	)
+	fmt.Println("prevented", all.Cancel())
+	serial.Wait()
+	<-all.Done()
+	fmt.Println("cancelled", all.IsCancelled(), serial.Count())
+	// Output:
+	// task 1
+	// task 3
+	// task 2
+	// all done
+	// prevented true
+	// cancelled true 0
+}
+
+func ExampleWhenAny() {
+	serial := scheduler.MakeTrampoline()
+
+	// A fast task races a slow timeout, which is cancelled once the fast
+	// task has finished.
+	work := serial.ScheduleFuture(time.Millisecond, func() { fmt.Println("work done") })
+	timeout := serial.ScheduleFuture(time.Second, func() { fmt.Println("timed out") })
+	race := scheduler.WhenAny(work, timeout)
+	serial.Wait()
+	<-race.Done()
+	fmt.Println("work", work.IsCancelled(), "timeout", timeout.IsCancelled())
+
+	// When a runner has already finished, the race is over immediately.
+	late := serial.ScheduleFuture(time.Millisecond, func() { fmt.Println("never") })
+	race = scheduler.WhenAny(work, late)
+	<-race.Done()
+	fmt.Println("late", late.IsCancelled(), "race", race.IsCancelled())
+	serial.Wait()
+	// Output:
+	// work done
+	// work false timeout true
+	// late true race false
+}
+
+// stuckrunner is a Runner that never finishes, not even when cancelled.
+type stuckrunner struct{}
+
+func (stuckrunner) Cancel() bool          { return false }
+func (stuckrunner) CancelCause(error)     {}
+func (stuckrunner) Cause() error          { return nil }
+func (stuckrunner) IsCancelled() bool     { return false }
+func (stuckrunner) Done() <-chan struct{} { return nil }
+
+func ExampleWhenAny_due() {
+	serial := scheduler.MakeTrampoline()
+
+	// Both tasks are due, but the second is cancelled as soon as the first
+	// has finished.
+	first := serial.Schedule(func() { fmt.Println("first") })
+	second := serial.Schedule(func() { fmt.Println("second") })
+	race := scheduler.WhenAny(first, second)
+	serial.Wait()
+	<-race.Done()
+	fmt.Println("second cancelled", second.IsCancelled())
+
+	// Runners that are not tasks are watched from a goroutine, which exits
+	// once the race is over, even when the runner never finishes.
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		work := serial.Schedule(func() {})
+		race = scheduler.WhenAny(work, stuckrunner{})
+		serial.Wait()
+		<-race.Done()
+	}
+	time.Sleep(10 * time.Millisecond)
+	fmt.Println("watchers exited", runtime.NumGoroutine() <= before)
+	// Output:
+	// first
+	// second cancelled true
+	// watchers exited true
+}
+
+func ExampleMakeTrampoline_release() {
+	serial := scheduler.MakeTrampoline()
+	task := func() {}
+
+	// Runners that are released right away let the scheduler reuse their
+	// tasks once they have run.
+	allocs := testing.AllocsPerRun(100, func() {
+		for i := 0; i < 100; i++ {
+			serial.Release(serial.Schedule(task))
+		}
+		serial.Wait()
+	})
+	fmt.Println("less than one allocation per task", allocs < 100)
+
+	// A runner may still be used until it is released.
+	runner := serial.ScheduleFuture(time.Millisecond, func() { fmt.Println("never") })
+	runner.Cancel()
+	serial.Release(runner)
+	serial.Wait()
+	fmt.Println("run", serial.Stats().Run, "cancelled", serial.Stats().Cancelled)
+	// Output:
+	// less than one allocation per task true
+	// run 10100 cancelled 1
+}
+
+func ExampleMakeTrampoline_scheduleWhenIdleFlush() {
+	serial := scheduler.MakeTrampoline()
+
+	// A host loop that sleeps until the next task is due and then flushes
+	// also runs the idle tasks, so it does not spin.
+	serial.ScheduleWhenIdle(func() { fmt.Println("idle") })
+	serial.ScheduleFuture(time.Millisecond, func() { fmt.Println("timer") })
+	serial.Schedule(func() { fmt.Println("now") })
+	for flushes := 0; flushes < 10; flushes++ {
+		due, ok := serial.NextDue()
+		if !ok {
+			fmt.Println("flushes", flushes)
+			break
+		}
+		time.Sleep(due)
+		serial.TryFlush()
+	}
+	// Output:
+	// now
+	// idle
+	// timer
+	// flushes 2
+}
@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// serial
+
+// serial holds the queue of a scheduler that accepts tasks from any goroutine
+// and runs them one at a time, like the CondLoop and EventLoop schedulers do.
+// It implements their schedule methods on top of the queue function each of
+// them provides, which adds a task to the queue to become due after due and
+// wakes up the goroutine running the tasks.
+type serial struct {
+	sync.Mutex
+	seq     uint64
+	tasks   taskqueue
+	current *futuretask
+	queue   func(t *futuretask, due time.Duration)
+}
+
+// push adds the task to the queue to become due after due. The lock must be
+// held.
+func (s *serial) push(t *futuretask, due time.Duration) {
+	if due > 0 {
+		s.tasks.put(t, time.Now().Add(due), s.seq)
+	} else {
+		s.tasks.append(t, time.Now(), s.seq)
+	}
+	s.seq++
+}
+
+func (s *serial) Schedule(task func()) Runner {
+	t := newtask(task)
+	s.queue(t, 0)
+	return t
+}
+
+func (s *serial) ScheduleRecursive(task func(self func())) Runner {
+	t := newtask(nil)
+	arm, self := t.recursion(func(due time.Duration) {
+		s.queue(t, due)
+	})
+	again := func() {
+		self(0)
+	}
+	t.run = func() {
+		task(again)
+	}
+	arm(0)
+	return t
+}
+
+func (s *serial) ScheduleFuture(due time.Duration, task func()) Runner {
+	t := newtask(task)
+	s.queue(t, due)
+	return t
+}
+
+func (s *serial) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	t := newtask(nil)
+	arm, self := t.recursion(func(due time.Duration) {
+		s.queue(t, due)
+	})
+	t.run = func() {
+		task(self)
+	}
+	arm(due)
+	return t
+}
+
+// dispatch removes the task from the queue and runs it with the lock
+// released. The lock must be held.
+func (s *serial) dispatch(task *futuretask) {
+	s.tasks.remove(task)
+	s.current = task
+	s.Unlock()
+	if task.begin() {
+		task.run()
+	}
+	s.Lock()
+	if task.queued() {
+		task.rearm()
+	} else {
+		task.finish()
+	}
+	s.current = nil
+}
+
+func (s *serial) Count() int {
+	s.Lock()
+	defer s.Unlock()
+	if s.current == nil {
+		return s.tasks.Len()
+	} else {
+		return s.tasks.Len() + 1
+	}
+}
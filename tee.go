@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// tee
+
+type tee struct {
+	schedulers []Scheduler
+}
+
+// MakeTee creates and returns a scheduler that dispatches every task to all
+// of the given schedulers. The returned instance implements the Scheduler
+// interface. The Runner returned by the schedule methods is a CompositeRunner
+// holding the runners of the task on every scheduler, or for a recursive
+// task a RecursiveRunner that embeds one, so calling Cancel on it will cancel
+// all of them. Wait waits for each of the schedulers in turn.
+//
+// The tee uses the clock of the first scheduler.
+func MakeTee(schedulers ...Scheduler) *tee {
+	return &tee{schedulers}
+}
+
+func (s *tee) each(schedule func(Scheduler) Runner) Runner {
+	r := &CompositeRunner{}
+	s.fill(r, schedule)
+	return r
+}
+
+// fill adds the runners of a task scheduled on every scheduler to the group.
+func (s *tee) fill(r *CompositeRunner, schedule func(Scheduler) Runner) {
+	for _, scheduler := range s.schedulers {
+		r.Add(schedule(scheduler))
+	}
+}
+
+// teerunner is the runner returned by a tee for a recursive task.
+type teerunner struct {
+	*CompositeRunner
+	runs int32
+}
+
+// Iterations returns the number of times the task called self so far, on any
+// of the schedulers of the tee.
+func (r *teerunner) Iterations() int {
+	return int(atomic.LoadInt32(&r.runs))
+}
+
+// iterate counts a call to self, unless the runner was cancelled.
+func (r *teerunner) iterate() {
+	if !r.IsCancelled() {
+		atomic.AddInt32(&r.runs, 1)
+	}
+}
+
+func (s *tee) Now() time.Time {
+	if len(s.schedulers) == 0 {
+		return time.Now()
+	}
+	return s.schedulers[0].Now()
+}
+
+func (s *tee) Since(t time.Time) time.Duration {
+	return s.Now().Sub(t)
+}
+
+func (s *tee) Schedule(task func()) Runner {
+	return s.each(func(scheduler Scheduler) Runner {
+		return scheduler.Schedule(task)
+	})
+}
+
+// ScheduleRecursive dispatches the task to all of the schedulers. The runner
+// returned is a RecursiveRunner whose Iterations counts the calls to self the
+// task made on all of the schedulers together.
+func (s *tee) ScheduleRecursive(task func(self func())) Runner {
+	r := &teerunner{CompositeRunner: &CompositeRunner{}}
+	s.fill(r.CompositeRunner, func(scheduler Scheduler) Runner {
+		return scheduler.ScheduleRecursive(func(self func()) {
+			task(func() {
+				r.iterate()
+				self()
+			})
+		})
+	})
+	return r
+}
+
+func (s *tee) ScheduleFuture(due time.Duration, task func()) Runner {
+	return s.each(func(scheduler Scheduler) Runner {
+		return scheduler.ScheduleFuture(due, task)
+	})
+}
+
+// ScheduleFutureRecursive dispatches the task to all of the schedulers. The
+// runner returned is a RecursiveRunner whose Iterations counts the calls to
+// self the task made on all of the schedulers together.
+func (s *tee) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	r := &teerunner{CompositeRunner: &CompositeRunner{}}
+	s.fill(r.CompositeRunner, func(scheduler Scheduler) Runner {
+		return scheduler.ScheduleFutureRecursive(due, func(self func(time.Duration)) {
+			task(func(due time.Duration) {
+				r.iterate()
+				self(due)
+			})
+		})
+	})
+	return r
+}
+
+func (s *tee) Wait() {
+	for _, scheduler := range s.schedulers {
+		scheduler.Wait()
+	}
+}
+
+func (s *tee) Gosched() {
+	for _, scheduler := range s.schedulers {
+		scheduler.Gosched()
+	}
+}
+
+// IsConcurrent returns true when all of the schedulers are concurrent. A tee
+// calls every scheduler for every task, so it is only safe to use from
+// multiple goroutines when each of them is. A tee without schedulers is not
+// concurrent.
+func (s *tee) IsConcurrent() bool {
+	for _, scheduler := range s.schedulers {
+		if !scheduler.IsConcurrent() {
+			return false
+		}
+	}
+	return len(s.schedulers) > 0
+}
+
+func (s *tee) Count() int {
+	count := 0
+	for _, scheduler := range s.schedulers {
+		count += scheduler.Count()
+	}
+	return count
+}
+
+func (s *tee) String() string {
+	return fmt.Sprintf("Tee{ schedulers = %d, tasks = %d }", len(s.schedulers), s.Count())
+}
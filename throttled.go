@@ -0,0 +1,94 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// throttled
+
+type throttled struct {
+	sync.Mutex
+	inner    Scheduler
+	interval time.Duration
+	next     time.Time
+}
+
+// MakeThrottled creates and returns a scheduler that dispatches its tasks on
+// the inner scheduler, spacing consecutive tasks at least minInterval apart.
+// The returned instance implements the Scheduler interface. Tasks scheduled
+// to run immediately are converted into future tasks on the inner scheduler
+// that are due once the interval since the previous task has passed. The
+// spacing is measured against the clock of the inner scheduler.
+//
+// Cancelling a task and calling Wait are delegated to the inner scheduler.
+func MakeThrottled(inner Scheduler, minInterval time.Duration) *throttled {
+	return &throttled{inner: inner, interval: minInterval}
+}
+
+// delay returns the due time for a task that would like to run after due,
+// pushed back so it runs at least the interval after the previously
+// dispatched task.
+func (s *throttled) delay(due time.Duration) time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	now := s.inner.Now()
+	at := now.Add(due)
+	if at.Before(s.next) {
+		at = s.next
+	}
+	s.next = at.Add(s.interval)
+	return at.Sub(now)
+}
+
+func (s *throttled) Now() time.Time {
+	return s.inner.Now()
+}
+
+func (s *throttled) Since(t time.Time) time.Duration {
+	return s.inner.Since(t)
+}
+
+func (s *throttled) Schedule(task func()) Runner {
+	return s.inner.ScheduleFuture(s.delay(0), task)
+}
+
+func (s *throttled) ScheduleRecursive(task func(self func())) Runner {
+	return s.inner.ScheduleFutureRecursive(s.delay(0), func(self func(time.Duration)) {
+		task(func() { self(s.delay(0)) })
+	})
+}
+
+func (s *throttled) ScheduleFuture(due time.Duration, task func()) Runner {
+	return s.inner.ScheduleFuture(s.delay(due), task)
+}
+
+func (s *throttled) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	return s.inner.ScheduleFutureRecursive(s.delay(due), func(self func(time.Duration)) {
+		task(func(due time.Duration) { self(s.delay(due)) })
+	})
+}
+
+func (s *throttled) Wait() {
+	s.inner.Wait()
+}
+
+func (s *throttled) Gosched() {
+	s.inner.Gosched()
+}
+
+// IsConcurrent returns whether the inner scheduler is concurrent. The time
+// of the next task is kept under a lock, so the throttle adds nothing that
+// would be unsafe to use from multiple goroutines.
+func (s *throttled) IsConcurrent() bool {
+	return s.inner.IsConcurrent()
+}
+
+func (s *throttled) Count() int {
+	return s.inner.Count()
+}
+
+func (s *throttled) String() string {
+	return fmt.Sprintf("Throttled{ interval = %v, inner = %v }", s.interval, s.inner)
+}
@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// traced
+
+type traced struct {
+	inner Scheduler
+	logf  func(format string, args ...interface{})
+	id    uint64
+}
+
+// MakeTraced creates and returns a scheduler that dispatches its tasks on the
+// inner scheduler and logs every scheduling and dispatch event by calling
+// logf, e.g. log.Printf. The returned instance implements the Scheduler
+// interface. Every task is given an id that is logged with its events: when
+// it is scheduled, including its due time, when a recursive task schedules
+// its next iteration, and when the task starts and finishes running. The
+// log forms a timeline of what the scheduler was asked to do and did. The
+// runners returned are those of the inner scheduler. When the inner scheduler
+// is concurrent, logf is called from multiple goroutines, which e.g.
+// log.Printf supports.
+func MakeTraced(inner Scheduler, logf func(format string, args ...interface{})) *traced {
+	return &traced{inner: inner, logf: logf}
+}
+
+func (s *traced) next() uint64 {
+	return atomic.AddUint64(&s.id, 1)
+}
+
+// trace wraps the run of an iteration of task id with start and finish events.
+func (s *traced) trace(id uint64, run func()) {
+	s.logf("task %d: start", id)
+	defer s.logf("task %d: finish", id)
+	run()
+}
+
+func (s *traced) Now() time.Time {
+	return s.inner.Now()
+}
+
+func (s *traced) Since(t time.Time) time.Duration {
+	return s.inner.Since(t)
+}
+
+func (s *traced) Schedule(task func()) Runner {
+	id := s.next()
+	s.logf("task %d: Schedule", id)
+	return s.inner.Schedule(func() {
+		s.trace(id, task)
+	})
+}
+
+func (s *traced) ScheduleRecursive(task func(self func())) Runner {
+	id := s.next()
+	s.logf("task %d: ScheduleRecursive", id)
+	return s.inner.ScheduleRecursive(func(self func()) {
+		s.trace(id, func() {
+			task(func() {
+				s.logf("task %d: self", id)
+				self()
+			})
+		})
+	})
+}
+
+func (s *traced) ScheduleFuture(due time.Duration, task func()) Runner {
+	id := s.next()
+	s.logf("task %d: ScheduleFuture due = %v", id, due)
+	return s.inner.ScheduleFuture(due, func() {
+		s.trace(id, task)
+	})
+}
+
+func (s *traced) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
+	id := s.next()
+	s.logf("task %d: ScheduleFutureRecursive due = %v", id, due)
+	return s.inner.ScheduleFutureRecursive(due, func(self func(time.Duration)) {
+		s.trace(id, func() {
+			task(func(due time.Duration) {
+				s.logf("task %d: self due = %v", id, due)
+				self(due)
+			})
+		})
+	})
+}
+
+func (s *traced) Wait() {
+	s.inner.Wait()
+}
+
+func (s *traced) Gosched() {
+	s.inner.Gosched()
+}
+
+// IsConcurrent returns whether the inner scheduler is concurrent. Task ids
+// are handed out atomically, so tracing adds nothing that would be unsafe to
+// use from multiple goroutines as long as logf is safe for that.
+func (s *traced) IsConcurrent() bool {
+	return s.inner.IsConcurrent()
+}
+
+func (s *traced) Count() int {
+	return s.inner.Count()
+}
+
+func (s *traced) String() string {
+	return fmt.Sprintf("Traced{ tasks = %d, inner = %v }", atomic.LoadUint64(&s.id), s.inner)
+}
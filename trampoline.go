@@ -1,112 +1,1931 @@
 package scheduler
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
 	"runtime"
-	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
 // futuretask
 
 type futuretask struct {
-	at     time.Time
-	run    func()
-	cancel chan struct{}
+	at       time.Time
+	seq      uint64
+	index    int
+	run      func()
+	cancel   unsafe.Pointer
+	state    int32
+	runs     int32
+	cause    error
+	done     unsafe.Pointer
+	cancels  unsafe.Pointer
+	priority int
+	round    uint64
+	delay    time.Duration
+	family   unsafe.Pointer
+	home     *trampoline
+	more     *taskextra
+	pooled   bool
+	released bool
+	resolved bool
 }
 
-func (t *futuretask) Cancel() {
-	if t.cancel != nil {
-		close(t.cancel)
+func newtask(run func()) *futuretask {
+	return &futuretask{index: unqueued, run: run}
+}
+
+// taskextra holds the state of a task that only some of the schedule methods
+// of a trampoline use, so a task scheduled with e.g. Schedule does not carry
+// it. It is only accessed from the goroutine that runs the trampoline.
+type taskextra struct {
+	deadline time.Time
+	ctx      context.Context
+	source   int
+	label    string
+	key      string
+	then     []*futuretask
+	waiting  bool
+	rest     func()
+}
+
+// noextra is the state of a task that has no extra state. It must not be
+// modified.
+var noextra taskextra
+
+// ext returns the extra state of the task for reading. For a task without
+// extra state it returns noextra.
+func (t *futuretask) ext() *taskextra {
+	if t.more == nil {
+		return &noextra
+	}
+	return t.more
+}
+
+// extend returns the extra state of the task for modification, allocating it
+// when the task has none.
+func (t *futuretask) extend() *taskextra {
+	if t.more == nil {
+		t.more = &taskextra{}
+	}
+	return t.more
+}
+
+// States of a task. A task is pending while it waits to run and running while
+// its function runs. A recursive task that was re-armed while running is
+// pending again afterwards. A task that was not run because its deadline had
+// passed is dropped. Done, dropped and cancelled are final, and a dropped
+// task counts as done everywhere but in ScheduleAfter.
+const (
+	taskPending int32 = iota
+	taskRunning
+	taskDone
+	taskCancelled
+	taskDropped
+)
+
+// Cancel the task. It returns true when the cancellation prevented the task
+// from running, or for a recursive task from running its next iteration. It
+// returns false when the task was already running, had already finished or
+// was already cancelled. Cancel is a no-op in the latter two cases. A
+// recursive task cancelled while running will not run again, but Cancel
+// returns false because the running iteration was not prevented. It is safe
+// to call Cancel more than once and from multiple goroutines concurrently.
+func (t *futuretask) Cancel() bool {
+	return t.cancelCause(ErrCancelled)
+}
+
+// CancelCause cancels the task like Cancel does and sets the cause of the
+// cancellation to err, or to ErrCancelled when err is nil. Only the first
+// call to Cancel or CancelCause sets the cause.
+func (t *futuretask) CancelCause(err error) {
+	t.cancelCause(err)
+}
+
+func (t *futuretask) cancelCause(err error) bool {
+	if err == nil {
+		err = ErrCancelled
+	}
+	for {
+		state := atomic.LoadInt32(&t.state)
+		if state == taskCancelled {
+			return false
+		}
+		if state == taskDone || state == taskDropped {
+			// The task itself is left alone, but its children may
+			// still be pending.
+			t.cancelChildren(err)
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&t.state, state, taskCancelled) {
+			t.cause = err
+			closechan(&t.cancel)
+			closechan(&t.done)
+			if cancels := t.owner(); cancels != nil {
+				atomic.AddInt32(cancels, 1)
+			}
+			t.cancelChildren(err)
+			t.settle()
+			return state == taskPending
+		}
+	}
+}
+
+// Iterations returns the number of times a recursive task called self to
+// re-arm itself so far, which gives insight in the progress of a long running
+// recursive task. It is safe to call Iterations from any goroutine, but on a
+// non-concurrent scheduler the count only changes while the scheduler is
+// running tasks.
+func (t *futuretask) Iterations() int {
+	return int(atomic.LoadInt32(&t.runs))
+}
+
+// iterate counts a call to self by a recursive task.
+func (t *futuretask) iterate() {
+	atomic.AddInt32(&t.runs, 1)
+}
+
+// recursion returns the functions that drive recursive task t. Calling arm
+// queues the task to become due after due by calling queue, unless the task
+// was cancelled. Self is the function the task calls to re-arm itself, which
+// arms the task and counts the iteration.
+func (t *futuretask) recursion(queue func(due time.Duration)) (arm, self func(due time.Duration)) {
+	arm = func(due time.Duration) {
+		if !t.IsCancelled() {
+			queue(due)
+		}
+	}
+	self = func(due time.Duration) {
+		if !t.IsCancelled() {
+			t.iterate()
+		}
+		arm(due)
+	}
+	return arm, self
+}
+
+// begin marks a pending task as running. It returns false when the task was
+// cancelled and should not run.
+func (t *futuretask) begin() bool {
+	return atomic.CompareAndSwapInt32(&t.state, taskPending, taskRunning)
+}
+
+// rearm marks a running task as pending again, after it was queued for its
+// next iteration.
+func (t *futuretask) rearm() {
+	atomic.CompareAndSwapInt32(&t.state, taskRunning, taskPending)
+}
+
+// cancelChildren cancels the children of the task with the given cause.
+func (t *futuretask) cancelChildren(err error) {
+	if f := (*family)(atomic.LoadPointer(&t.family)); f != nil {
+		for _, child := range f.orphan() {
+			child.CancelCause(err)
+		}
 	}
 }
 
-// trampoline
+// family holds the children of a task, the tasks that are cancelled along
+// with it, and the functions to call once the task is done or cancelled. It
+// is only allocated for a task that gets a child or such a function.
+type family struct {
+	sync.Mutex
+	cancelled bool
+	children  []*futuretask
+	settled   bool
+	hooks     []func()
+}
+
+// kin returns the family of the task, allocating it when the task has none.
+func (t *futuretask) kin() *family {
+	f := (*family)(atomic.LoadPointer(&t.family))
+	if f == nil {
+		f = &family{}
+		if !atomic.CompareAndSwapPointer(&t.family, nil, unsafe.Pointer(f)) {
+			f = (*family)(atomic.LoadPointer(&t.family))
+		}
+	}
+	return f
+}
+
+// adopt makes child a child of task t, so cancelling t cancels child as
+// well. When t was already cancelled, child is cancelled right away.
+func (t *futuretask) adopt(child *futuretask) {
+	f := t.kin()
+	f.Lock()
+	if f.cancelled {
+		f.Unlock()
+		child.CancelCause(t.Cause())
+		return
+	}
+	// Children that were cancelled have already cancelled their own
+	// children, so they need not be kept.
+	children := f.children[:0]
+	for _, c := range f.children {
+		if !c.IsCancelled() {
+			children = append(children, c)
+		}
+	}
+	for i := len(children); i < len(f.children); i++ {
+		f.children[i] = nil
+	}
+	f.children = append(children, child)
+	f.Unlock()
+}
+
+// orphan marks the family as cancelled and returns the children, so they can
+// be cancelled. Children adopted after that are cancelled when adopted.
+func (f *family) orphan() []*futuretask {
+	f.Lock()
+	defer f.Unlock()
+	f.cancelled = true
+	children := f.children
+	f.children = nil
+	return children
+}
+
+// whenDone registers hook to be called once, right after the task is done or
+// cancelled, on the goroutine that finished or cancelled it. On a trampoline
+// the hook of a task that ran is thus called before the next task is
+// dispatched. It returns false without registering the hook when the task
+// was already done or cancelled.
+func (t *futuretask) whenDone(hook func()) bool {
+	if t.final() {
+		return false
+	}
+	f := t.kin()
+	f.Lock()
+	if f.settled {
+		f.Unlock()
+		return false
+	}
+	f.hooks = append(f.hooks, hook)
+	f.Unlock()
+	// The task may have become final before the hook was added, in which
+	// case settling again calls it.
+	if t.final() {
+		t.settle()
+	}
+	return true
+}
+
+// settle calls the hooks registered with whenDone. It is called once the task
+// is done or cancelled, and calls every hook only once.
+func (t *futuretask) settle() {
+	f := (*family)(atomic.LoadPointer(&t.family))
+	if f == nil {
+		return
+	}
+	f.Lock()
+	f.settled = true
+	hooks := f.hooks
+	f.hooks = nil
+	f.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// final returns true when the task is done or cancelled.
+func (t *futuretask) final() bool {
+	state := atomic.LoadInt32(&t.state)
+	return state == taskDone || state == taskDropped || state == taskCancelled
+}
+
+// owner returns the counter of cancelled tasks of the scheduler that owns the
+// task, or nil when the task is not owned by a trampoline.
+func (t *futuretask) owner() *int32 {
+	return (*int32)(atomic.LoadPointer(&t.cancels))
+}
+
+// own makes the scheduler with the given counter of cancelled tasks the owner
+// of the task.
+func (t *futuretask) own(cancels *int32) {
+	atomic.StorePointer(&t.cancels, unsafe.Pointer(cancels))
+}
+
+// claim makes the trampoline the owner and the home of the task. Tasks that
+// queue themselves again, like the iterations of a recursive task, do so in
+// their home, so they follow the task when it is migrated to another
+// trampoline.
+func (s *trampoline) claim(t *futuretask) {
+	t.own(&s.cancels)
+	t.home = s
+}
+
+// rearmAfter queues the next iteration of a recursive task in its home to
+// become due after due.
+func (t *futuretask) rearmAfter(due time.Duration) {
+	t.home.enqueueAfter(t, due)
+	t.home.withdraw(t)
+}
+
+// newhome returns a new task whose home is the trampoline, for a task that
+// needs its home before it is first queued.
+func (s *trampoline) newhome(run func()) *futuretask {
+	t := newtask(run)
+	t.home = s
+	return t
+}
+
+// cancelChan returns a channel that is closed when the task is cancelled.
+func (t *futuretask) cancelChan() <-chan struct{} {
+	return lazychan(&t.cancel)
+}
+
+// Done returns a channel that is closed when the task has finished running
+// or when it was cancelled.
+func (t *futuretask) Done() <-chan struct{} {
+	return lazychan(&t.done)
+}
+
+// finish marks the task as done, unless it was cancelled.
+func (t *futuretask) finish() {
+	for {
+		state := atomic.LoadInt32(&t.state)
+		if state == taskDone || state == taskDropped || state == taskCancelled {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&t.state, state, taskDone) {
+			closechan(&t.done)
+			t.settle()
+			return
+		}
+	}
+}
+
+// drop marks a pending task as dropped because its deadline had passed,
+// unless it was cancelled.
+func (t *futuretask) drop() {
+	if atomic.CompareAndSwapInt32(&t.state, taskPending, taskDropped) {
+		closechan(&t.done)
+		t.settle()
+	}
+}
+
+// dropped returns true when the task was dropped because its deadline had
+// passed.
+func (t *futuretask) dropped() bool {
+	return atomic.LoadInt32(&t.state) == taskDropped
+}
+
+// The cancel and done channels of a task are only created once they are asked
+// for, so tasks that are never waited on do not allocate them. A channel that
+// is closed before it was asked for is replaced by the shared closed channel.
+
+var closed = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// lazychan returns the channel stored at p, storing a new channel first when
+// there is none.
+func lazychan(p *unsafe.Pointer) chan struct{} {
+	if c := atomic.LoadPointer(p); c != nil {
+		return *(*chan struct{})(c)
+	}
+	c := make(chan struct{})
+	if atomic.CompareAndSwapPointer(p, nil, unsafe.Pointer(&c)) {
+		return c
+	}
+	return *(*chan struct{})(atomic.LoadPointer(p))
+}
+
+// closechan closes the channel stored at p, or stores the shared closed
+// channel when there is none. It must be called at most once for p.
+func closechan(p *unsafe.Pointer) {
+	if !atomic.CompareAndSwapPointer(p, nil, unsafe.Pointer(&closed)) {
+		close(*(*chan struct{})(atomic.LoadPointer(p)))
+	}
+}
+
+// ctxDone returns the done channel of the context the task was scheduled
+// with, or nil when it was scheduled without a context.
+func (t *futuretask) ctxDone() <-chan struct{} {
+	if ctx := t.ext().ctx; ctx != nil {
+		return ctx.Done()
+	}
+	return nil
+}
+
+// Cause returns the cause of the cancellation of the task, or nil when the
+// task has not been cancelled.
+func (t *futuretask) Cause() error {
+	if !t.IsCancelled() {
+		return nil
+	}
+	// Receiving from the closed cancel channel guarantees the cause set by
+	// CancelCause is visible.
+	<-t.cancelChan()
+	return t.cause
+}
+
+// IsCancelled returns true when Cancel has been called on the task.
+func (t *futuretask) IsCancelled() bool {
+	return atomic.LoadInt32(&t.state) == taskCancelled
+}
+
+// TaskInfo describes a pending task in a snapshot of the queue of a
+// scheduler. The function of the task is not part of it, so a snapshot can be
+// stored and restored later by supplying the functions again by label.
+type TaskInfo struct {
+	// Offset is the duration from the moment of the snapshot until the task
+	// is due, or zero when it was already due.
+	Offset time.Duration
+
+	// Label is the label the task was scheduled with.
+	Label string
+
+	// Priority is the priority the task was scheduled with.
+	Priority int
+
+	// Cancelled is true when the task was cancelled but not yet removed from
+	// the queue.
+	Cancelled bool
+
+	// Idle is true when the task was scheduled with ScheduleWhenIdle and
+	// waits for the scheduler to become idle.
+	Idle bool
+}
+
+// Stats holds cumulative counts of the tasks handled by a scheduler.
+type Stats struct {
+	// Scheduled is the number of tasks added to the queue, including every
+	// iteration of a recursive task.
+	Scheduled int
+
+	// Run is the number of tasks that were run.
+	Run int
+
+	// Cancelled is the number of tasks that were dropped from the queue
+	// because they were cancelled before they could run.
+	Cancelled int
+
+	// Dropped is the number of tasks that were not run because their
+	// deadline had already passed by the time they were dispatched.
+	Dropped int
+}
+
+// trampoline
+
+type trampoline struct {
+	gid     string
+	clock   Clock
+	seq     uint64
+	tasks   taskqueue
+	current *futuretask
+	recover func(interface{})
+	before  func()
+	after   func()
+	max     int
+	paused  int32
+	pausing sync.Mutex
+	pauses  chan struct{}
+	started time.Time
+	stats   Stats
+	err     error
+	pending int32
+	cancels int32
+	rand    *rand.Rand
+	running bool
+	dropped func(Runner)
+	drained []func()
+	horizon time.Duration
+	beyond  func(at time.Time)
+	sources map[int]uint64
+	round   uint64
+	busy    bool
+	onIdle  func()
+	onBusy  func()
+	unique  map[string]*futuretask
+	drain   int
+	stuck   func(count int)
+	streak  int
+	limit   int
+	guarded bool
+	owner   string
+	window  time.Duration
+	until   time.Time
+	halt    <-chan struct{}
+	free    []*futuretask
+	slab    []futuretask
+	batch   time.Time
+}
+
+// MakeTrampoline creates and returns a non-concurrent scheduler that runs
+// all tasks on a single goroutine. The returned instance implements the
+// Scheduler interface. Tasks scheduled will be dispatched asynchronously
+// because they are added to a serial queue. Now when the Wait method is called
+// all tasks scheduled on the serial queue will be performed in dispatch order.
+//
+// The Trampoline scheduler is not safe to use from multiple goroutines at the
+// concurrently. It should be used purely for scheduling tasks from a single
+// goroutine. The only methods that may be called from other goroutines are
+// SafeLen, String, Pause, Resume and IsPaused, and the methods of the
+// returned runners.
+func MakeTrampoline() *trampoline {
+	return MakeTrampolineWithClock(realtime{})
+}
+
+// MakeTrampolineWithClock creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that uses the given clock to determine the
+// current time and to wait for future tasks to become due.
+func MakeTrampolineWithClock(clock Clock) *trampoline {
+	return &trampoline{gid: Gid(), clock: clock}
+}
+
+// MakeTrampolineRecover creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that recovers from a panic in a task. The
+// value passed to panic is handed to the handler and the scheduler then
+// continues running the remaining tasks.
+func MakeTrampolineRecover(handler func(interface{})) *trampoline {
+	s := MakeTrampoline()
+	s.recover = handler
+	return s
+}
+
+// MakeTrampolineHooked creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that calls before right before running a task
+// and after right after the task has returned. The after hook is also called
+// when the task panics. Either hook may be nil.
+func MakeTrampolineHooked(before, after func()) *trampoline {
+	s := MakeTrampoline()
+	s.before = before
+	s.after = after
+	return s
+}
+
+// MakeBoundedTrampoline creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one whose TrySchedule and TryScheduleFuture methods
+// refuse to add a task when the queue already holds max pending tasks.
+func MakeBoundedTrampoline(max int) *trampoline {
+	s := MakeTrampoline()
+	s.max = max
+	return s
+}
+
+// MakeTrampolineCap creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that allocates room for capacity tasks up
+// front. Scheduling a burst of up to capacity tasks will then not have to
+// grow the queue, and the queue is never shrunk below that size. The tasks
+// scheduled with Schedule and ScheduleFuture are allocated in blocks of
+// capacity tasks, so such a burst costs a single allocation for its tasks
+// instead of one per task. A block is only freed once none of its tasks is
+// referenced anymore, so holding on to a single runner keeps its block
+// alive.
+func MakeTrampolineCap(capacity int) *trampoline {
+	s := MakeTrampoline()
+	s.tasks.reserve(capacity)
+	return s
+}
+
+// MakeTrampolineFair creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that runs tasks scheduled to run immediately
+// before any future task, even when the future task became due earlier. Tasks
+// scheduled to run immediately still run in the order they were scheduled.
+// This keeps a burst of future tasks that all become due at the same time
+// from starving tasks that are scheduled while the burst is running. Note
+// that a recursive task that keeps calling self will in turn hold up future
+// tasks until it stops.
+func MakeTrampolineFair() *trampoline {
+	s := MakeTrampoline()
+	s.tasks.fair = true
+	return s
+}
+
+// MakeTrampolineLIFO creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that runs the most recently scheduled task
+// first. Tasks that are scheduled to run immediately are treated as being due
+// at the same time and form a stack, which gives depth-first instead of
+// breadth-first processing. Tasks scheduled to run in the future are still
+// ordered by due time, only the order of tasks due at the same time changes.
+func MakeTrampolineLIFO() *trampoline {
+	s := MakeTrampoline()
+	s.tasks.lifo = true
+	return s
+}
+
+// MakeTrampolineMaxDrain creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that detects a suspected livelock. When a
+// single call to Wait, or to one of its variants, Run, Flush or WaitBudget,
+// dispatches more than max tasks without the queue ever becoming empty, e.g.
+// because a recursive task keeps re-arming itself without delay, livelock is
+// called with the number of tasks dispatched. Wait then continues and calls
+// livelock again after another max tasks, unless the handler stops it, e.g.
+// by calling CancelAll. The count starts over every time the queue becomes
+// empty. When livelock is nil, Wait panics instead.
+func MakeTrampolineMaxDrain(max int, livelock func(count int)) *trampoline {
+	s := MakeTrampoline()
+	s.drain = max
+	s.stuck = livelock
+	return s
+}
+
+// MakeTrampolineGuarded creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that checks it is used from a single
+// goroutine. The goroutine that first schedules a task or runs the queue
+// becomes the owner of the scheduler. Scheduling a task or running the queue
+// from any other goroutine panics, instead of silently corrupting the queue.
+// Finding out the current goroutine is slow, so this is meant as a debugging
+// aid during development only. Schedulers made by the other constructors do
+// not perform the check.
+func MakeTrampolineGuarded() *trampoline {
+	s := MakeTrampoline()
+	s.guarded = true
+	return s
+}
+
+// MakeTrampolineTimeScale creates and returns a non-concurrent scheduler
+// like MakeTrampoline does, but one that uses a clock that runs scale times
+// as fast as real time. So with a scale of 2 a task scheduled to run after
+// one second runs after half a second. Call SetTimeScale to change the scale
+// while the scheduler is running, e.g. from a speed slider in a simulation.
+func MakeTrampolineTimeScale(scale float64) *trampoline {
+	if scale < 0 {
+		scale = 0
+	}
+	return MakeTrampolineWithClock(newScaledClock(scale))
+}
+
+// MakeChannelDriven creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one whose clock is driven by the times received
+// from the ticks channel instead of by the wall clock, e.g. for a simulation
+// that is stepped by an external sequencer. Now returns the last tick that
+// was received. The first call to Now, which is made when the first task is
+// scheduled, waits for the first tick to set the start time. Waiting for a
+// future task consumes ticks until a tick at or after the due time of the
+// task arrives, and then the tasks that are due at or before that tick are
+// run. A tick that is before the previous tick does not move the clock back.
+// While waiting for ticks, cancelling the task or the context passed to
+// WaitContext ends the wait as it would on a trampoline. Once the ticks
+// channel is closed, Wait and its variants stop waiting and return, leaving
+// the tasks that did not become due in the queue.
+func MakeChannelDriven(ticks <-chan time.Time) *trampoline {
+	clock := newTickClock(ticks)
+	s := MakeTrampolineWithClock(clock)
+	s.halt = clock.closed
+	return s
+}
+
+// MakeTrampolineCoalesce creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that coalesces the waits for future tasks that
+// are due close together. Once it has waited for a task to become due, it
+// also runs the tasks that become due within window from then, without
+// waiting for each of them separately. Those tasks may thus run up to window
+// early. This reduces the number of timers and wake-ups for a dense schedule
+// of future tasks. A window of zero waits for every task separately, like
+// MakeTrampoline does.
+func MakeTrampolineCoalesce(window time.Duration) *trampoline {
+	s := MakeTrampoline()
+	s.window = window
+	return s
+}
+
+// MakeTrampolineHorizon creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that will not schedule a task further ahead
+// than max. A task that would become due after that horizon is clamped to it.
+// This keeps e.g. a misconfigured backoff from parking a task forever.
+func MakeTrampolineHorizon(max time.Duration) *trampoline {
+	s := MakeTrampoline()
+	s.horizon = max
+	return s
+}
+
+// MakeTrampolineHorizonReject creates and returns a non-concurrent scheduler
+// like MakeTrampolineHorizon does, but one that rejects a task that would
+// become due after the horizon instead of clamping it. The task is not
+// queued, the runner returned for it is cancelled with ErrBeyondHorizon and
+// rejected is called with the time the task would have become due.
+func MakeTrampolineHorizonReject(max time.Duration, rejected func(at time.Time)) *trampoline {
+	s := MakeTrampolineHorizon(max)
+	if rejected == nil {
+		rejected = func(time.Time) {}
+	}
+	s.beyond = rejected
+	return s
+}
+
+// MakeTrampolineWithRand creates and returns a non-concurrent scheduler like
+// MakeTrampoline does, but one that draws the jitter for ScheduleFutureJitter
+// from the given source of random numbers. Seed it with a fixed value to make
+// the jittered due times reproducible.
+func MakeTrampolineWithRand(r *rand.Rand) *trampoline {
+	s := MakeTrampoline()
+	s.rand = r
+	return s
+}
+
+func (s *trampoline) Len() int {
+	return s.tasks.Len()
+}
+
+// SafeLen returns the number of tasks in the queue like Len does, but unlike
+// Len it is safe to call from any goroutine, e.g. for monitoring.
+func (s *trampoline) SafeLen() int {
+	return int(atomic.LoadInt32(&s.pending))
+}
+
+func (s *trampoline) enqueue(t *futuretask, at time.Time) {
+	s.guard()
+	if s.horizon > 0 {
+		if limit := s.clock.Now().Add(s.horizon); at.After(limit) {
+			if s.beyond != nil {
+				t.CancelCause(ErrBeyondHorizon)
+				s.beyond(at)
+				return
+			}
+			at = limit
+		}
+	}
+	if !t.queued() {
+		s.stats.Scheduled++
+	}
+	if t.owner() == nil {
+		s.claim(t)
+	}
+	t.delay = at.Sub(s.clock.Now())
+	s.nextround(t)
+	s.tasks.put(t, at, s.nextseq())
+	s.sync()
+	s.wake()
+}
+
+// enqueueAfter adds the task to the queue to run after the due time. A task
+// with a due time of zero or less is queued like enqueueNow does, so it runs
+// in the order it was scheduled in relative to tasks scheduled to run
+// immediately, regardless of the resolution of the clock.
+func (s *trampoline) enqueueAfter(t *futuretask, due time.Duration) {
+	if due <= 0 {
+		s.enqueueNow(t)
+	} else {
+		s.enqueue(t, s.clock.Now().Add(due))
+	}
+}
+
+// enqueueAt adds the task to the queue to run at the given time. A task for a
+// time that is not in the future is queued like enqueueNow does.
+func (s *trampoline) enqueueAt(t *futuretask, at time.Time) {
+	if at.After(s.clock.Now()) {
+		s.enqueue(t, at)
+	} else {
+		s.enqueueNow(t)
+	}
+}
+
+// enqueueNow adds the task to the queue to run as soon as possible, after all
+// tasks that are already due.
+func (s *trampoline) enqueueNow(t *futuretask) {
+	s.guard()
+	if !t.queued() {
+		s.stats.Scheduled++
+	}
+	if t.owner() == nil {
+		s.claim(t)
+	}
+	s.nextround(t)
+	s.tasks.append(t, s.batchtime(), s.nextseq())
+	s.sync()
+	s.wake()
+}
+
+// batchtime returns the time at which a task queued to run immediately is
+// due. All tasks queued to run immediately in between two dispatches are due
+// at the same time, so they are ordered by priority, round and sequence
+// number alone, however far the clock advanced while they were queued.
+func (s *trampoline) batchtime() time.Time {
+	if s.batch.IsZero() {
+		s.batch = s.clock.Now()
+	}
+	return s.batch
+}
+
+// guard panics when the scheduler is guarded and is used from another
+// goroutine than the one that used it first.
+func (s *trampoline) guard() {
+	if !s.guarded {
+		return
+	}
+	gid := Gid()
+	if s.owner == "" {
+		s.owner = gid
+	} else if gid != s.owner {
+		panic(fmt.Sprintf("scheduler: trampoline owned by goroutine %s used from goroutine %s", s.owner, gid))
+	}
+}
+
+// wake marks the scheduler busy when a task was added to it while it was
+// idle and calls the handler set with OnBusy.
+func (s *trampoline) wake() {
+	if !s.busy {
+		s.busy = true
+		if s.onBusy != nil {
+			s.onBusy()
+		}
+	}
+}
+
+// withdraw takes a task that was just queued again by a recursive task out of
+// the queue when the task was cancelled while it was being queued. Cancel may
+// be called from another goroutine at any moment, so it can slip in between
+// checking whether the task was cancelled and queueing it.
+func (s *trampoline) withdraw(t *futuretask) {
+	if t.IsCancelled() && t.queued() {
+		s.tasks.remove(t)
+		s.stats.Cancelled++
+		s.sync()
+	}
+}
+
+// nextseq returns the sequence number for the next task to be queued. In LIFO
+// mode the sequence numbers count down, so of the tasks due at the same time
+// the most recently scheduled comes first.
+func (s *trampoline) nextseq() uint64 {
+	seq := s.seq
+	s.seq++
+	if s.tasks.lifo {
+		return ^seq
+	}
+	return seq
+}
+
+// nextround gives a task the round in which it takes its turn among the tasks
+// of other sources that are due at the same time. A source gets at most one
+// task per round, and a source that was idle joins in at the round currently
+// being dispatched, so it cannot claim turns for the rounds it sat out. Rounds
+// are only handed out once ScheduleSource has been used.
+func (s *trampoline) nextround(t *futuretask) {
+	if s.sources == nil {
+		return
+	}
+	source := t.ext().source
+	round := s.sources[source] + 1
+	if round <= s.round {
+		round = s.round + 1
+	}
+	s.sources[source] = round
+	t.round = round
+}
+
+// sync publishes the length of the queue for SafeLen and String.
+func (s *trampoline) sync() {
+	atomic.StoreInt32(&s.pending, int32(s.tasks.Len()))
+}
+
+func (s *trampoline) Now() time.Time {
+	return s.clock.Now()
+}
+
+func (s *trampoline) Since(t time.Time) time.Duration {
+	return s.clock.Now().Sub(t)
+}
+
+func (s *trampoline) Schedule(task func()) Runner {
+	t := s.alloc(task)
+	s.enqueueNow(t)
+	return t
+}
+
+// ScheduleBatch dispatches a group of tasks to the scheduler like calling
+// Schedule for every task in turn does, and returns a runner for every task
+// in the same order. The tasks are allocated together and room for all of
+// them is made in the queue up front, so scheduling a large group costs a
+// few allocations instead of one or more per task.
+func (s *trampoline) ScheduleBatch(tasks []func()) []Runner {
+	batch := make([]futuretask, len(tasks))
+	runners := make([]Runner, len(tasks))
+	s.tasks.grow(len(tasks))
+	for i, task := range tasks {
+		t := &batch[i]
+		t.index = unqueued
+		t.run = task
+		s.enqueueNow(t)
+		runners[i] = t
+	}
+	return runners
+}
+
+// ScheduleRecursive dispatches a task to the scheduler. Every call to self
+// queues the next iteration of the task behind all tasks that are already
+// queued, including tasks due at the same time, so a recursive task cannot
+// starve its peers. Calling self more than once during an iteration queues
+// only a single next iteration. In LIFO mode the next iteration runs first.
+func (s *trampoline) ScheduleRecursive(task func(self func())) Runner {
+	t := s.newhome(nil)
+	arm, self := t.recursion(t.rearmAfter)
+	again := func() {
+		self(0)
+	}
+	t.run = func() {
+		task(again)
+	}
+	arm(0)
+	return t
+}
+
+// ScheduleRecursiveLimit dispatches a task to the scheduler like
+// ScheduleRecursive does, but the task will run at most max times. When the
+// task calls self after it has run max times, the task is not re-armed and
+// instead exceeded is called. Pass nil for exceeded to silently stop.
+func (s *trampoline) ScheduleRecursiveLimit(max int, task func(self func()), exceeded func()) Runner {
+	count := 0
+	return s.ScheduleRecursive(func(self func()) {
+		count++
+		task(func() {
+			if count < max {
+				self()
+			} else if exceeded != nil {
+				exceeded()
+			}
+		})
+	})
+}
+
+// ScheduleFuture dispatches a task to the scheduler to be executed after the
+// due time. A task with a due time of zero or less is queued exactly like a
+// task scheduled with Schedule, so tasks scheduled with either method before
+// any of them runs are dispatched strictly in the order they were scheduled
+// in, however the clock advanced in between.
+func (s *trampoline) ScheduleFuture(due time.Duration, task func()) Runner {
+	t := s.alloc(task)
+	s.enqueueAfter(t, due)
+	return t
+}
+
+// ScheduleFutureJitter dispatches a task to the scheduler like ScheduleFuture
+// does, but with a due time that is randomly moved by up to jitter in either
+// direction. Spreading the due times of e.g. retries this way avoids all of
+// them running at the same moment.
+func (s *trampoline) ScheduleFutureJitter(due, jitter time.Duration, task func()) Runner {
+	if jitter > 0 {
+		if s.rand != nil {
+			due += time.Duration(s.rand.Int63n(2*int64(jitter)+1)) - jitter
+		} else {
+			due += time.Duration(rand.Int63n(2*int64(jitter)+1)) - jitter
+		}
+	}
+	return s.ScheduleFuture(due, task)
+}
+
+// TrySchedule dispatches a task to the scheduler like Schedule does, unless
+// the scheduler is bounded and its queue is full. In that case the task is
+// not scheduled and false is returned.
+func (s *trampoline) TrySchedule(task func()) (Runner, bool) {
+	if s.full() {
+		return nil, false
+	}
+	return s.Schedule(task), true
+}
+
+// TryScheduleFuture dispatches a task to the scheduler like ScheduleFuture
+// does, unless the scheduler is bounded and its queue is full. In that case
+// the task is not scheduled and false is returned.
+func (s *trampoline) TryScheduleFuture(due time.Duration, task func()) (Runner, bool) {
+	if s.full() {
+		return nil, false
+	}
+	return s.ScheduleFuture(due, task), true
+}
+
+func (s *trampoline) full() bool {
+	return s.max > 0 && s.tasks.Len() >= s.max
+}
+
+// ScheduleAt dispatches a task to the scheduler to be executed at the given
+// time. A task scheduled at a time that has already passed is queued behind
+// the tasks that are already due, just like a task scheduled with Schedule.
+func (s *trampoline) ScheduleAt(at time.Time, task func()) Runner {
+	t := newtask(task)
+	s.enqueueAt(t, at)
+	return t
+}
+
+// ScheduleAtLeast dispatches a task to the scheduler to be executed at the
+// given time like ScheduleAt does, but not sooner than min from now. So a
+// task for a time that has already passed, e.g. an expiry that was missed,
+// runs after min instead of immediately. This keeps a batch of past-due tasks
+// from all firing at once.
+func (s *trampoline) ScheduleAtLeast(at time.Time, min time.Duration, task func()) Runner {
+	if earliest := s.clock.Now().Add(min); at.Before(earliest) {
+		at = earliest
+	}
+	return s.ScheduleAt(at, task)
+}
+
+// ScheduleDeadline dispatches a task to the scheduler to be executed at the
+// given time like ScheduleAt does, but only as long as the task is still
+// valid. When the task is dispatched after the deadline has passed, e.g.
+// because the tasks before it took too long, the task is considered stale and
+// is dropped instead of run. See OnDropped for a way to be notified of this.
+func (s *trampoline) ScheduleDeadline(at, deadline time.Time, task func()) Runner {
+	t := newtask(task)
+	t.extend().deadline = deadline
+	s.enqueueAt(t, at)
+	return t
+}
+
+// ScheduleTimeout dispatches a task to the scheduler like Schedule does and
+// calls onTimeout when the task ran for longer than timeout. The trampoline
+// runs tasks on a single goroutine and cannot interrupt a running task, so the
+// timeout is only detected after the task has returned. See the Goroutine
+// scheduler for a variant that calls onTimeout while the task is running.
+func (s *trampoline) ScheduleTimeout(timeout time.Duration, task func(), onTimeout func()) Runner {
+	t := s.newhome(nil)
+	t.run = func() {
+		start := t.home.clock.Now()
+		task()
+		if t.home.clock.Now().Sub(start) > timeout {
+			onTimeout()
+		}
+	}
+	s.enqueueNow(t)
+	return t
+}
+
+// ScheduleAfter dispatches a task to the scheduler to run right after the
+// task of runner r has finished running. When the task of r is cancelled, or
+// dropped because its deadline passed, the task is cancelled as well. When r
+// already finished, the task is scheduled to run immediately. The runner r
+// must have been returned by one of the schedule methods of this scheduler,
+// ScheduleAfter panics otherwise.
+func (s *trampoline) ScheduleAfter(r Runner, task func()) Runner {
+	p, ok := r.(*futuretask)
+	if !ok || p.owner() != &s.cancels {
+		panic("scheduler: ScheduleAfter called with a runner of another scheduler")
+	}
+	t := newtask(task)
+	s.claim(t)
+	switch {
+	case p.queued() || p.ext().waiting || p == s.current:
+		t.extend().waiting = true
+		x := p.extend()
+		x.then = append(x.then, t)
+	case p.IsCancelled():
+		t.CancelCause(p.Cause())
+	case p.dropped():
+		t.CancelCause(ErrDropped)
+	default:
+		s.enqueueNow(t)
+	}
+	return t
+}
+
+// ScheduleChild dispatches a task to the scheduler like Schedule does and
+// makes it a child of the task of runner parent. Cancelling the parent
+// cancels the child with the same cause, and through the child all of its own
+// descendants, so the tasks form a tree that can be torn down from any node.
+// When the parent was already cancelled, the child is cancelled right away.
+// Since a child is always a new task, the tree cannot contain a cycle. A
+// parent keeps its children that were not cancelled for as long as it
+// exists, whether the parent or the children have finished running or not.
+// The parent may be a task of any scheduler, but ScheduleChild panics when
+// parent was not returned by a schedule method of a scheduler in this
+// package.
+func (s *trampoline) ScheduleChild(parent Runner, task func()) Runner {
+	p, ok := parent.(*futuretask)
+	if !ok {
+		panic("scheduler: ScheduleChild called with a runner that is not a task")
+	}
+	t := newtask(task)
+	p.adopt(t)
+	if !t.IsCancelled() {
+		s.enqueueNow(t)
+	}
+	return t
+}
+
+// Reschedule moves the pending task of runner r so it becomes due after
+// newDue, keeping the runner valid. This makes it possible to debounce a task
+// by pushing it back every time new input arrives. When the task already ran,
+// was cancelled or was not scheduled on this scheduler, Reschedule does
+// nothing and returns false.
+func (s *trampoline) Reschedule(r Runner, newDue time.Duration) bool {
+	t, ok := r.(*futuretask)
+	if !ok || t.owner() != &s.cancels || !t.queued() || t.IsCancelled() {
+		return false
+	}
+	s.enqueueAfter(t, newDue)
+	return true
+}
+
+// ScheduleUnique dispatches a task to the scheduler to be executed after the
+// due time, unless a task scheduled earlier with the same key is still
+// pending. In that case the pending task is replaced by the new task and
+// moved so it becomes due after the due time from now, and the runner of the
+// pending task is returned. So a rapid series of calls with the same key
+// collapses into a single run of the last task, which makes ScheduleUnique a
+// debounce-by-key primitive. Once the task for a key has run or was
+// cancelled, the next call with that key schedules a new task.
+func (s *trampoline) ScheduleUnique(key string, due time.Duration, task func()) Runner {
+	t := s.unique[key]
+	if t == nil || t.owner() != &s.cancels || !t.queued() || t.IsCancelled() {
+		if s.unique == nil {
+			s.unique = make(map[string]*futuretask)
+		}
+		t = newtask(nil)
+		t.extend().key = key
+		s.unique[key] = t
+	}
+	t.run = task
+	s.enqueueAfter(t, due)
+	return t
+}
+
+// resolve schedules the tasks that were waiting for task t to finish. When t
+// did not run, because it was cancelled or dropped, they are cancelled with
+// the same cause.
+func (s *trampoline) resolve(t *futuretask, ran bool) {
+	var then []*futuretask
+	if x := t.more; x != nil {
+		if x.key != "" && s.unique[x.key] == t {
+			delete(s.unique, x.key)
+		}
+		then = x.then
+		x.then = nil
+	}
+	for _, c := range then {
+		c.more.waiting = false
+		switch {
+		case t.IsCancelled():
+			c.CancelCause(t.Cause())
+			s.resolve(c, false)
+		case !ran:
+			c.CancelCause(ErrDropped)
+			s.resolve(c, false)
+		default:
+			s.enqueueNow(c)
+		}
+	}
+	t.resolved = true
+	if t.released {
+		s.recycle(t)
+	}
+}
+
+// Release tells the scheduler that the caller is done with the runner, so
+// the task behind it can be reused for a task scheduled later. This saves an
+// allocation per task when scheduling many short-lived tasks. The task is
+// only reused once it has also been run, dropped or cancelled and removed
+// from the queue, so a runner may be released right after it was returned.
+// After releasing a runner, the caller must not use it in any way again, nor
+// keep it in e.g. a CompositeRunner, because it may by then stand for another
+// task. Only the runners returned by Schedule and ScheduleFuture are reused,
+// releasing any other runner or releasing a runner twice does nothing.
+// Release must be called from the goroutine that runs the scheduler.
+func (s *trampoline) Release(runner Runner) {
+	t, ok := runner.(*futuretask)
+	if !ok || !t.pooled || t.released {
+		return
+	}
+	t.released = true
+	if t.resolved {
+		s.recycle(t)
+	}
+}
+
+// alloc returns a task for the function, reusing a released task when there
+// is one. A trampoline made with MakeTrampolineCap takes new tasks from a
+// block of tasks it allocates capacity at a time.
+func (s *trampoline) alloc(run func()) *futuretask {
+	if n := len(s.free); n > 0 {
+		t := s.free[n-1]
+		s.free[n-1] = nil
+		s.free = s.free[:n-1]
+		t.run = run
+		return t
+	}
+	if s.tasks.capacity > 0 {
+		if len(s.slab) == 0 {
+			s.slab = make([]futuretask, s.tasks.capacity)
+		}
+		t := &s.slab[0]
+		s.slab = s.slab[1:]
+		t.index = unqueued
+		t.run = run
+		t.pooled = true
+		return t
+	}
+	t := newtask(run)
+	t.pooled = true
+	return t
+}
+
+// recycle clears the task and adds it to the released tasks to be reused.
+// The channels handed out by the task are not reused, so goroutines still
+// holding on to them are not affected.
+func (s *trampoline) recycle(t *futuretask) {
+	*t = futuretask{index: unqueued, pooled: true}
+	s.free = append(s.free, t)
+}
+
+// ScheduleWhenIdle dispatches a task to the scheduler that only runs when the
+// scheduler is idle, i.e. when no other task is due, e.g. for background work
+// like compaction. As long as other tasks keep becoming due, the task is
+// pushed back behind them until there is a gap. Tasks scheduled with
+// ScheduleWhenIdle run in the order they were scheduled in. Flush and
+// TryFlush run the idle tasks that were scheduled before they were called
+// once no other task is due, so a loop that sleeps for NextDue and then
+// flushes runs them too.
+func (s *trampoline) ScheduleWhenIdle(task func()) Runner {
+	t := newtask(task)
+	s.enqueueIdle(t)
+	return t
+}
 
-type trampoline struct {
-	gid     string
-	tasks   []futuretask
-	current *futuretask
+// enqueueIdle adds the task to the tail of the tasks that wait for the
+// scheduler to become idle.
+func (s *trampoline) enqueueIdle(t *futuretask) {
+	s.guard()
+	s.stats.Scheduled++
+	s.claim(t)
+	s.tasks.appendIdle(t, s.clock.Now(), s.nextseq())
+	s.sync()
+	s.wake()
 }
 
-// MakeTrampoline creates and returns a non-concurrent scheduler that runs
-// all tasks on a single goroutine. The returned instance implements the
-// Scheduler interface. Tasks scheduled will be dispatched asynchronously
-// because they are added to a serial queue. Now when the Wait method is called
-// all tasks scheduled on the serial queue will be performed in dispatch order.
-//
-// The Trampoline scheduler is not safe to use from multiple goroutines at the
-// concurrently. It should be used purely for scheduling tasks from a single
-// goroutine.
-func MakeTrampoline() *trampoline {
-	return &trampoline{gid: Gid()}
+// ScheduleLabeled dispatches a task to the scheduler like Schedule does, but
+// attaches a label to it. The label is shown by Dump and returned by
+// CurrentLabel while the task is running, e.g. to find out from a hook which
+// part of a program scheduled the task. Tasks scheduled with the other
+// schedule methods have an empty label.
+func (s *trampoline) ScheduleLabeled(label string, task func()) Runner {
+	t := newtask(task)
+	t.extend().label = label
+	s.enqueueNow(t)
+	return t
 }
 
-func (s *trampoline) Len() int {
-	return len(s.tasks)
+// CurrentLabel returns the label of the task that is currently running, or
+// an empty string when no task is running or the task has no label.
+func (s *trampoline) CurrentLabel() string {
+	if s.current == nil {
+		return ""
+	}
+	return s.current.ext().label
 }
 
-func (s *trampoline) Less(i, j int) bool {
-	return s.tasks[i].at.Before(s.tasks[j].at)
+// SchedulePriority dispatches a task to the scheduler like Schedule does, but
+// with the given priority. Among tasks that are due at the same time, tasks
+// with a higher priority run first. Tasks with the same priority run in the
+// order they were scheduled. Tasks scheduled with the other schedule methods
+// have priority 0, so a negative priority runs a task after them.
+//
+// Tasks scheduled to run immediately in between two dispatches, e.g. by the
+// same task or before calling Wait, count as due at the same time, also on
+// the real-time clock where no two calls see the same time. Priority does not
+// move a task ahead of a task that became due before that.
+func (s *trampoline) SchedulePriority(priority int, task func()) Runner {
+	t := newtask(task)
+	t.priority = priority
+	s.enqueueNow(t)
+	return t
 }
 
-func (s *trampoline) Swap(i, j int) {
-	s.tasks[i], s.tasks[j] = s.tasks[j], s.tasks[i]
+// ScheduleSource dispatches a task to the scheduler like Schedule does and
+// tags it with the id of the source that produced it. Tasks due at the same
+// time are dispatched round-robin across their sources, so a source that
+// schedules a burst of tasks cannot hold up the tasks of other sources until
+// its burst has been run. Tasks dispatched by the other schedule methods
+// belong to source 0. Tasks scheduled to run immediately in between two
+// dispatches count as due at the same time, also on the real-time clock.
+func (s *trampoline) ScheduleSource(source int, task func()) Runner {
+	if s.sources == nil {
+		s.sources = make(map[int]uint64)
+	}
+	t := newtask(task)
+	t.extend().source = source
+	s.enqueueNow(t)
+	return t
 }
 
-func (s *trampoline) Now() time.Time {
-	return time.Now()
+// ScheduleContext dispatches a task to the scheduler like Schedule does, but
+// the task is cancelled automatically when the context is done before the
+// task runs.
+func (s *trampoline) ScheduleContext(ctx context.Context, task func()) Runner {
+	t := newtask(task)
+	t.extend().ctx = ctx
+	s.enqueueNow(t)
+	return t
 }
 
-func (s *trampoline) Since(t time.Time) time.Duration {
-	return time.Since(t)
+// ScheduleWithContext dispatches a task to the scheduler like ScheduleContext
+// does and passes the context to the task when it runs. This carries values
+// stored in the context at the moment the task was scheduled, e.g. for
+// logging or tracing, over to the task. When the context is done before the
+// task runs, the task is cancelled.
+func (s *trampoline) ScheduleWithContext(ctx context.Context, task func(context.Context)) Runner {
+	t := newtask(func() { task(ctx) })
+	t.extend().ctx = ctx
+	s.enqueueNow(t)
+	return t
 }
 
-func (s *trampoline) Schedule(task func()) Runner {
-	t := futuretask{time.Now(), task, make(chan struct{})}
-	s.tasks = append(s.tasks, t)
-	sort.Stable(s)
-	return &t
+// ScheduleFutureContext dispatches a task to the scheduler like ScheduleFuture
+// does, but the task is cancelled automatically when the context is done
+// before the task runs. While Wait is waiting for the task to become due, the
+// context being done ends the wait immediately.
+func (s *trampoline) ScheduleFutureContext(ctx context.Context, due time.Duration, task func()) Runner {
+	t := newtask(task)
+	t.extend().ctx = ctx
+	s.enqueueAfter(t, due)
+	return t
 }
 
-func (s *trampoline) ScheduleRecursive(task func(self func())) Runner {
-	t := futuretask{cancel: make(chan struct{})}
-	self := func() {
-		t.at = time.Now()
-		s.tasks = append(s.tasks, t)
-		sort.Stable(s)
+// ScheduleFutureRecursiveContext dispatches a task to the scheduler like
+// ScheduleFutureRecursive does and passes the context to every iteration of
+// the task. When the context is done, the task is cancelled with the error of
+// the context as cause. A pending iteration is then dropped, and calling self
+// no longer schedules another iteration. This suits e.g. a polling loop that
+// should stop on shutdown.
+func (s *trampoline) ScheduleFutureRecursiveContext(ctx context.Context, due time.Duration, task func(ctx context.Context, self func(time.Duration))) Runner {
+	t := s.newhome(nil)
+	t.extend().ctx = ctx
+	arm, self := t.recursion(t.rearmAfter)
+	stop := func() {
+		if err := ctx.Err(); err != nil {
+			t.CancelCause(err)
+		}
+	}
+	next := func(due time.Duration) {
+		stop()
+		self(due)
 	}
 	t.run = func() {
-		task(self)
+		task(ctx, next)
 	}
-	self()
-	return &t
+	stop()
+	arm(due)
+	return t
 }
 
-func (s *trampoline) ScheduleFuture(due time.Duration, task func()) Runner {
-	t := futuretask{time.Now().Add(due), task, make(chan struct{})}
-	s.tasks = append(s.tasks, t)
-	sort.Stable(s)
-	return &t
+// OnDropped sets a handler that is called with the runner of every task
+// scheduled with ScheduleDeadline that is dropped because its deadline had
+// passed. Pass nil to remove the handler.
+func (s *trampoline) OnDropped(handler func(Runner)) {
+	s.dropped = handler
+}
+
+// OnIdle sets a handler that is called when Wait, one of its variants, Run,
+// Flush or WaitBudget has run all tasks in the queue and the tasks registered
+// with ScheduleOnDrain. Together with OnBusy it lets a host loop know when it
+// can stop driving the scheduler. Pass nil to remove the handler.
+func (s *trampoline) OnIdle(handler func()) {
+	s.onIdle = handler
+}
+
+// OnBusy sets a handler that is called when a task is added to the scheduler
+// while it is idle, i.e. before the first Wait or after Wait or one of the
+// other methods that run the queue emptied it. Tasks scheduled while the
+// queue is being run do not call the handler, even when the queue was
+// momentarily empty. Pass nil to remove the handler.
+func (s *trampoline) OnBusy(handler func()) {
+	s.onBusy = handler
 }
 
 func (s *trampoline) ScheduleFutureRecursive(due time.Duration, task func(self func(time.Duration))) Runner {
-	t := futuretask{cancel: make(chan struct{})}
-	self := func(due time.Duration) {
-		t.at = time.Now().Add(due)
-		s.tasks = append(s.tasks, t)
-		sort.Stable(s)
+	t := s.newhome(nil)
+	arm, self := t.recursion(t.rearmAfter)
+	t.run = func() {
+		task(self)
 	}
+	arm(due)
+	return t
+}
+
+// ScheduleFutureRecursiveFixed dispatches a task to the scheduler like
+// ScheduleFutureRecursive does. However, calling self(due) schedules the next
+// iteration for due after the time the current iteration was scheduled for,
+// instead of due after the current time. So the time it takes to run the task
+// does not add up over the iterations and the cadence stays fixed. When the
+// task overran by more than due, the next iteration is scheduled to run
+// immediately and the cadence continues from there.
+func (s *trampoline) ScheduleFutureRecursiveFixed(due time.Duration, task func(self func(time.Duration))) Runner {
+	t := s.newhome(nil)
+	var anchor time.Time
+	arm, self := t.recursion(func(due time.Duration) {
+		if anchor.IsZero() {
+			t.home.enqueueAfter(t, due)
+		} else {
+			t.home.enqueueAt(t, anchor.Add(due))
+		}
+		t.home.withdraw(t)
+	})
 	t.run = func() {
+		anchor = t.at
 		task(self)
 	}
-	self(due)
-	return &t
+	arm(due)
+	return t
+}
+
+// SchedulePeriodic dispatches a task to the scheduler to be executed first
+// after the first duration and then repeatedly every period. Every next due
+// time is computed by adding the period to the previous due time, so the
+// schedule does not drift. When running a task overran one or more ticks,
+// those missed ticks are coalesced into the next tick on the schedule.
+// Cancel the returned runner to stop the task from running again.
+func (s *trampoline) SchedulePeriodic(first, period time.Duration, task func()) Runner {
+	if period <= 0 {
+		panic("scheduler: non-positive period for SchedulePeriodic")
+	}
+	t := s.newhome(nil)
+	t.run = func() {
+		task()
+		if t.IsCancelled() {
+			return
+		}
+		at := t.at.Add(period)
+		if now := t.home.clock.Now(); at.Before(now) {
+			missed := (now.Sub(at) + period - 1) / period
+			at = at.Add(missed * period)
+		}
+		t.home.enqueue(t, at)
+		t.home.withdraw(t)
+	}
+	s.enqueue(t, s.clock.Now().Add(first))
+	return t
+}
+
+// ScheduleFuturePeriodic dispatches a task to the scheduler to be executed
+// periodically, like SchedulePeriodic does. The task is passed the time
+// elapsed on the scheduler's clock since it was last run, so it can account
+// for the actual interval between runs. On its first run the task is passed
+// the period.
+func (s *trampoline) ScheduleFuturePeriodic(first, period time.Duration, task func(delta time.Duration)) Runner {
+	var prev time.Time
+	var t *futuretask
+	t = s.SchedulePeriodic(first, period, func() {
+		now := t.home.clock.Now()
+		delta := period
+		if !prev.IsZero() {
+			delta = now.Sub(prev)
+		}
+		prev = now
+		task(delta)
+	}).(*futuretask)
+	return t
+}
+
+// ScheduleE dispatches a task that may fail to the scheduler. An error
+// returned by the task is reported by WaitE, other methods of waiting for the
+// scheduler ignore it.
+func (s *trampoline) ScheduleE(task func() error) Runner {
+	t := s.newhome(nil)
+	t.run = func() {
+		if err := task(); err != nil && t.home.err == nil {
+			t.home.err = err
+		}
+	}
+	s.enqueueNow(t)
+	return t
 }
 
+// Wait runs all tasks in the queue, including the tasks they schedule, until
+// the queue is empty. Calling Wait from a task that is run by Wait returns
+// immediately, because the outer call will continue to run the remaining
+// tasks after that task returns.
 func (s *trampoline) Wait() {
-	for s.RunTask() {
+	if !s.enter() {
+		return
+	}
+	defer s.leave()
+	s.started = time.Time{}
+	for {
+		for s.RunTask() {
+		}
+		if !s.settle() {
+			break
+		}
+	}
+	s.err = nil
+}
+
+// enter marks the scheduler as running its queue and returns true, or returns
+// false when it was already running it. Wait and its variants return
+// immediately when called from a task they run, because the outer call will
+// continue to run the remaining tasks after that task returns.
+func (s *trampoline) enter() bool {
+	if s.running {
+		return false
+	}
+	s.running = true
+	s.streak, s.limit = 0, s.drain
+	return true
+}
+
+// leave marks the scheduler as no longer running its queue.
+func (s *trampoline) leave() {
+	s.running = false
+}
+
+// settle is called when running the queue stopped. When the queue is empty,
+// it calls the next task registered with ScheduleOnDrain and returns true, so
+// the caller goes on to run the tasks it scheduled. Once no such task is left,
+// it starts numbering tasks from zero again and marks the scheduler idle.
+// It returns false when the queue is not empty or when it marked the
+// scheduler idle.
+func (s *trampoline) settle() bool {
+	if s.tasks.Len() > 0 {
+		return false
+	}
+	if len(s.drained) > 0 && !s.IsPaused() {
+		task := s.drained[0]
+		s.drained[0] = nil
+		s.drained = s.drained[1:]
+		task()
+		return true
+	}
+	// Start numbering from zero again, so even a scheduler that runs
+	// forever will never exhaust the 64 bit sequence numbers.
+	s.seq = 0
+	if s.busy {
+		s.busy = false
+		if s.onIdle != nil {
+			s.onIdle()
+		}
+	}
+	return false
+}
+
+// tally counts a task dispatched while running the queue and reports a
+// suspected livelock when more than the maximum set with
+// MakeTrampolineMaxDrain were dispatched without the queue becoming empty.
+func (s *trampoline) tally() {
+	if s.drain <= 0 || !s.running {
+		return
+	}
+	if s.tasks.Len() == 0 {
+		s.streak, s.limit = 0, s.drain
+	} else if s.streak++; s.streak > s.limit {
+		s.limit += s.drain
+		s.livelock(s.streak)
+	}
+}
+
+// livelock reports that Wait dispatched count tasks without the queue ever
+// becoming empty.
+func (s *trampoline) livelock(count int) {
+	if s.stuck == nil {
+		panic(fmt.Sprintf("scheduler: suspected livelock, Wait dispatched %d tasks without the queue becoming empty", count))
+	}
+	s.stuck(count)
+}
+
+// ScheduleOnDrain registers a task that Wait will call once, at the moment
+// the queue has become empty. When the task schedules new tasks, Wait runs
+// those first and only calls the next task registered with ScheduleOnDrain
+// when the queue is empty again. The variants of Wait, Run, Flush and
+// WaitBudget call the task as well once they have emptied the queue. Flush
+// and WaitBudget leave the tasks it schedules for their next call.
+func (s *trampoline) ScheduleOnDrain(task func()) {
+	s.drained = append(s.drained, task)
+}
+
+// WaitE behaves like Wait, but stops running tasks as soon as a task
+// scheduled with ScheduleE returns an error and then returns that error. The
+// tasks that did not run yet are left in the queue. Call WaitE again to
+// continue running them or call CancelAll to abandon them. When all tasks
+// ran without error, nil is returned. Called from a task that is being run,
+// WaitE returns nil immediately.
+func (s *trampoline) WaitE() error {
+	if !s.enter() {
+		return nil
+	}
+	defer s.leave()
+	s.started = time.Time{}
+	s.err = nil
+	for {
+		for s.RunTask() {
+			if err := s.err; err != nil {
+				s.err = nil
+				return err
+			}
+		}
+		if !s.settle() {
+			return nil
+		}
+	}
+}
+
+// WaitAll behaves like Wait, but returns the errors returned by the tasks
+// scheduled with ScheduleE, in the order the tasks were dispatched. Unlike
+// WaitE it does not stop at the first error, so every task is attempted. When
+// all tasks ran without error, nil is returned. Called from a task that is
+// being run, WaitAll returns nil immediately.
+//
+// A panic in a task is not turned into an error. When the scheduler was made
+// with MakeTrampolineRecover, the panic is passed to the handler and WaitAll
+// goes on to run the remaining tasks. A task that should report a panic along
+// with the other errors can recover from it and return it as an error.
+func (s *trampoline) WaitAll() []error {
+	if !s.enter() {
+		return nil
+	}
+	defer s.leave()
+	var errs []error
+	s.started = time.Time{}
+	s.err = nil
+	for {
+		for s.RunTask() {
+			if err := s.err; err != nil {
+				s.err = nil
+				errs = append(errs, err)
+			}
+		}
+		if !s.settle() {
+			return errs
+		}
+	}
+}
+
+// WaitContext behaves like Wait, but will also return when the context is
+// done, both while waiting for a future task to become due and in between
+// running tasks. When that happens the context's error is returned and the
+// tasks that did not run yet are left in the queue, so a later call to Wait
+// or WaitContext will resume running them. Called from a task that is being
+// run, WaitContext returns nil immediately.
+func (s *trampoline) WaitContext(ctx context.Context) error {
+	if !s.enter() {
+		return nil
+	}
+	defer s.leave()
+	s.started = time.Time{}
+	for ctx.Err() == nil {
+		if !s.runTask(ctx.Done(), nil) && (ctx.Err() != nil || !s.settle()) {
+			break
+		}
+	}
+	s.err = nil
+	return ctx.Err()
+}
+
+// Run runs the tasks in the queue like WaitContext does, so the scheduler can
+// be used where a Run(ctx context.Context) error method is expected, e.g. as
+// a service or a member of an errgroup. It returns nil once the queue is
+// empty and the error of the context when the context is done first. Run
+// returns nil immediately when it is called from a task that is run by Run or
+// Wait, the outer call will run the remaining tasks. After Run returned it may
+// be called again to run tasks scheduled since.
+func (s *trampoline) Run(ctx context.Context) error {
+	return s.WaitContext(ctx)
+}
+
+// Stats returns the cumulative counts of tasks scheduled, run and cancelled
+// by the scheduler.
+func (s *trampoline) Stats() Stats {
+	return s.stats
+}
+
+// Elapsed returns the time elapsed on the scheduler's clock since the most
+// recent call to Wait or WaitContext dispatched its first task. It returns
+// zero when no task has been dispatched since Wait was called. Calling Elapsed
+// from a running task reports how long the current Wait has been running.
+func (s *trampoline) Elapsed() time.Duration {
+	if s.started.IsZero() {
+		return 0
+	}
+	return s.clock.Now().Sub(s.started)
+}
+
+// CancelAll cancels every task in the queue and empties it, so a subsequent
+// call to Wait returns immediately. Tasks that were already cancelled
+// individually are simply dropped.
+func (s *trampoline) CancelAll() {
+	for _, t := range s.tasks.drain() {
+		t.Cancel()
+		s.stats.Cancelled++
+		s.resolve(t, false)
+	}
+	s.sync()
+}
+
+// Shutdown winds down the scheduler. Tasks that are due are run to
+// completion in dispatch order, but tasks that are due in the future are
+// cancelled and removed from the queue. Unlike CancelAll, this lets work that
+// is already due finish while dropping scheduled timers. Tasks scheduled by
+// the tasks that run during Shutdown are left in the queue. Shutdown returns
+// the number of future tasks it cancelled.
+func (s *trampoline) Shutdown() int {
+	cancelled := 0
+	now := s.clock.Now()
+	for _, t := range s.tasks.sorted() {
+		if t.at.After(now) {
+			s.tasks.remove(t)
+			if !t.IsCancelled() {
+				t.CancelCause(ErrShutdown)
+				cancelled++
+			}
+			s.stats.Cancelled++
+			s.resolve(t, false)
+		}
+	}
+	s.sync()
+	s.Flush()
+	return cancelled
+}
+
+// BeginScope returns a new scope in which tasks can be scheduled without
+// adding them to the queue of the scheduler. The scope is itself a trampoline
+// that uses the same clock. Call Commit to move the tasks of the scope into
+// the queue or Discard to cancel them. Runners returned by the scope remain
+// valid after the tasks were committed.
+func (s *trampoline) BeginScope() *trampoline {
+	scope := MakeTrampolineWithClock(s.clock)
+	scope.tasks.fair = s.tasks.fair
+	scope.tasks.lifo = s.tasks.lifo
+	return scope
+}
+
+// Commit moves the pending tasks of the scope into the queue of the scheduler.
+// The tasks keep their due times, so they are merged into the queue in the
+// order they would have run in. Tasks that are due at the same time as tasks
+// already in the queue run after those tasks. Recursive and periodic tasks
+// queue their next iterations in the scheduler instead of in the scope.
+func (s *trampoline) Commit(scope *trampoline) {
+	scope.migrate(s)
+}
+
+// MigrateInto moves the pending tasks of the scheduler into the queue of dst
+// like Commit does, leaving the queue of the scheduler empty. This allows
+// building up work on a staging scheduler and handing it over as a whole. The
+// tasks keep their due times and dispatch order, and the runners returned for
+// them stay valid, so cancelling one cancels the task in dst. Recursive and
+// periodic tasks queue their next iterations in dst, and errors of tasks
+// scheduled with ScheduleE are reported by dst.
+func (s *trampoline) MigrateInto(dst *trampoline) {
+	s.migrate(dst)
+}
+
+// Discard cancels the pending tasks of the scope.
+func (s *trampoline) Discard(scope *trampoline) {
+	scope.CancelAll()
+}
+
+// migrate moves the tasks in the queue into the queue of dst in dispatch
+// order, preserving their due times and whether they were ready or waiting
+// for the scheduler to become idle.
+func (s *trampoline) migrate(dst *trampoline) {
+	tasks := s.tasks.sorted()
+	index := make([]int, len(tasks))
+	for i, t := range tasks {
+		index[i] = t.index
+	}
+	s.tasks.drain()
+	s.sync()
+	for i, t := range tasks {
+		t.rehome(dst)
+		if t.IsCancelled() {
+			s.stats.Cancelled++
+			s.resolve(t, false)
+			continue
+		}
+		dst.stats.Scheduled++
+		dst.nextround(t)
+		switch index[i] {
+		case inready:
+			dst.tasks.append(t, t.at, dst.nextseq())
+		case inidle:
+			dst.tasks.appendIdle(t, t.at, dst.nextseq())
+		default:
+			dst.tasks.put(t, t.at, dst.nextseq())
+		}
+		dst.wake()
+	}
+	dst.sync()
+}
+
+// rehome makes dst the owner and the home of the task and of all tasks
+// waiting for it to finish.
+func (t *futuretask) rehome(dst *trampoline) {
+	dst.claim(t)
+	for _, c := range t.ext().then {
+		c.rehome(dst)
+	}
+}
+
+// Reset returns the scheduler to the state it was in when it was created, so
+// the same instance can be reused for a fresh batch of tasks. Tasks still in
+// the queue are cancelled and removed, tasks registered with ScheduleOnDrain
+// are dropped and the stats, sequence numbers and paused state are cleared.
+// The configuration the scheduler was created with is kept. Reset panics when
+// it is called from a task, because the scheduler is then still running.
+func (s *trampoline) Reset() {
+	if s.running || s.current != nil {
+		panic("scheduler: Reset called while running a task")
+	}
+	for _, t := range s.tasks.drain() {
+		t.Cancel()
+		s.resolve(t, false)
+	}
+	if s.tasks.capacity > 0 {
+		s.tasks.reserve(s.tasks.capacity)
+	}
+	s.seq = 0
+	s.sources, s.round = nil, 0
+	s.busy = false
+	s.unique = nil
+	s.owner = ""
+	s.until = time.Time{}
+	s.batch = time.Time{}
+	s.free = nil
+	s.started = time.Time{}
+	s.stats = Stats{}
+	s.err = nil
+	s.drained = nil
+	atomic.StoreInt32(&s.cancels, 0)
+	s.Resume()
+	s.sync()
+}
+
+// SetTimeScale changes the speed of the clock of a scheduler created with
+// MakeTrampolineTimeScale. Due times of tasks are kept, but time advances at
+// the new scale from now on. When Wait is waiting for a future task, it
+// computes again how long to wait with the new scale. A scale of zero stops
+// time, so no future task becomes due until the scale is raised again.
+// SetTimeScale may be called from any goroutine. It panics when the scheduler
+// was not created with MakeTrampolineTimeScale.
+func (s *trampoline) SetTimeScale(scale float64) {
+	clock, ok := s.clock.(*scaledclock)
+	if !ok {
+		panic("scheduler: SetTimeScale called on a trampoline without a scaled clock")
+	}
+	clock.setScale(scale)
+}
+
+// Pause suspends running tasks. While paused, Wait and WaitContext return
+// without running any task and the queue is left intact. A Wait that is
+// waiting for a future task to become due returns without running it. Pause
+// may be called from any goroutine.
+func (s *trampoline) Pause() {
+	s.pausing.Lock()
+	defer s.pausing.Unlock()
+	if atomic.SwapInt32(&s.paused, 1) == 0 && s.pauses != nil {
+		close(s.pauses)
+	}
+}
+
+// Resume undoes a previous call to Pause. The next call to Wait continues
+// running tasks in the same order and at the same due times as before, so a
+// future task that became due while paused will run immediately. Resume may
+// be called from any goroutine.
+func (s *trampoline) Resume() {
+	s.pausing.Lock()
+	defer s.pausing.Unlock()
+	if atomic.SwapInt32(&s.paused, 0) != 0 {
+		s.pauses = nil
+	}
+}
+
+// pauseChan returns a channel that is closed when the scheduler is paused, so
+// a wait for a future task can be ended by Pause.
+func (s *trampoline) pauseChan() <-chan struct{} {
+	s.pausing.Lock()
+	defer s.pausing.Unlock()
+	if s.pauses == nil {
+		s.pauses = make(chan struct{})
+		if s.IsPaused() {
+			close(s.pauses)
+		}
+	}
+	return s.pauses
+}
+
+// IsPaused returns true when the scheduler has been paused.
+func (s *trampoline) IsPaused() bool {
+	return atomic.LoadInt32(&s.paused) != 0
+}
+
+// Yield is called from a running task to split off the rest of its work.
+// The rest is scheduled to run behind the tasks that are due at this moment,
+// including future tasks that have become due while the task was running,
+// so a long task can give those tasks a chance to run. The rest continues
+// the running task, so the task is queued again to run the rest and Yield
+// returns the runner of the task. Cancelling that runner cancels the rest,
+// and the task is only done once the rest has run. This way a long chain of
+// yields holds on to no more than the rest that is still to run. Called
+// outside of a task, Yield schedules rest like Schedule does. When the
+// running task was already queued again, e.g. by calling self in a
+// recursive task, the rest is scheduled as a child of the task instead. In
+// LIFO mode the rest runs first.
+func (s *trampoline) Yield(rest func()) Runner {
+	t := s.current
+	switch {
+	case t == nil:
+		return s.Schedule(rest)
+	case t.queued() || t.ext().rest != nil:
+		return s.ScheduleChild(t, rest)
+	case t.IsCancelled():
+		return t
 	}
+	t.extend().rest = rest
+	s.enqueueNow(t)
+	s.withdraw(t)
+	return t
 }
 
 func (s *trampoline) Gosched() {
@@ -119,56 +1938,306 @@ func (s *trampoline) Gosched() {
 }
 
 func (s *trampoline) RunTask() bool {
-	if len(s.tasks) == 0 {
-		return false
+	return s.runTask(nil, nil)
+}
+
+// prune removes the tasks that were cancelled since the last call from the
+// queue.
+func (s *trampoline) prune() {
+	if atomic.SwapInt32(&s.cancels, 0) != 0 {
+		for _, t := range s.tasks.prune() {
+			s.stats.Cancelled++
+			s.resolve(t, false)
+		}
+		s.sync()
 	}
-	s.current = &s.tasks[0]
-	s.tasks = s.tasks[1:]
-	if time.Until(s.current.at) < 999*time.Millisecond {
-		s.ShortWaitAndRun(s.current)
-	} else {
-		s.LongWaitAndRun(s.current)
+}
+
+// NextDue returns the time until the task at the head of the queue becomes
+// due, or zero when it is already due. Cancelled tasks are skipped. When the
+// queue is empty it returns false. Together with Flush this allows driving the
+// scheduler from an external loop that sleeps until the next task is due.
+func (s *trampoline) NextDue() (time.Duration, bool) {
+	s.prune()
+	task := s.tasks.peek()
+	if task == nil && len(s.tasks.idle) > 0 {
+		return 0, true
 	}
-	s.current = nil
-	return true
+	if task == nil {
+		return 0, false
+	}
+	due := task.at.Sub(s.clock.Now())
+	if due < 0 {
+		due = 0
+	}
+	return due, true
 }
 
-func (s *trampoline) ShortWaitAndRun(task *futuretask) {
-	for time.Now().Before(task.at) {
-		select {
-		case <-task.cancel:
+// Pending returns the number of queued tasks that are due at the moment
+// Pending is called and the number of queued tasks that are due later. A call
+// to Flush will run the ready tasks and not block when there is at least one.
+// Like NextDue it should be called between dispatches, not concurrently with
+// them.
+func (s *trampoline) Pending() (ready, future int) {
+	s.prune()
+	ready = s.tasks.due(s.clock.Now())
+	return ready, s.tasks.Len() - ready
+}
+
+// Flush runs the tasks that are due at the moment Flush is called, in
+// dispatch order, and then returns. Unlike Wait it does not run tasks that
+// are scheduled while flushing, including the next iteration of a recursive
+// task, nor does it wait for future tasks to become due. Those tasks are left
+// in the queue for the next call. This makes Flush a step function for
+// driving the scheduler from an external loop, e.g. once per frame.
+//
+// The tasks scheduled while flushing are skipped rather than stopped at, so
+// every task that was due when Flush was called runs, also in LIFO mode where
+// a task scheduled while flushing comes first, and in fair mode where a new
+// ready task comes before the future tasks that are due. Once those tasks
+// ran, Flush runs the tasks scheduled with ScheduleWhenIdle before it was
+// called, for as long as no other task is due. Called from a task that is
+// being run, Flush returns immediately.
+func (s *trampoline) Flush() {
+	if !s.enter() {
+		return
+	}
+	defer s.leave()
+	s.flush()
+	for s.settle() {
+	}
+}
+
+// flush runs the tasks that are due at the start of Flush.
+func (s *trampoline) flush() {
+	s.guard()
+	s.prune()
+	cutoff, now := s.seq, s.clock.Now()
+	queued := func(t *futuretask) bool {
+		seq := t.seq
+		if s.tasks.lifo {
+			seq = ^seq
+		}
+		return t.queued() && t.owner() == &s.cancels && seq < cutoff
+	}
+	for _, t := range s.tasks.sortedDue(now) {
+		s.prune()
+		if s.IsPaused() {
 			return
-		default:
-			runtime.Gosched()
 		}
+		if !queued(t) || t.at.After(now) {
+			// Removed, migrated or queued again while flushing.
+			continue
+		}
+		s.dispatch(t)
 	}
-	select {
-	case <-task.cancel:
-		return
+	for {
+		s.prune()
+		t := s.tasks.peekIdle(s.clock.Now())
+		if t == nil || !queued(t) || s.IsPaused() {
+			return
+		}
+		s.dispatch(t)
+	}
+}
+
+// TryFlush runs the tasks that are due like Flush does, never waiting for a
+// future task to become due. It returns true when tasks remain in the queue
+// afterwards, in which case NextDue tells when the next one is due.
+func (s *trampoline) TryFlush() bool {
+	s.Flush()
+	s.prune()
+	return s.tasks.Len() > 0
+}
+
+// WaitBudget runs tasks that are due, in dispatch order, until maxTasks tasks
+// have run or maxTime has elapsed on the scheduler's clock, whichever comes
+// first, and returns the number of tasks it ran. A limit of zero or less means
+// no limit. Tasks that are scheduled while running count towards the budget
+// when they are due. WaitBudget never waits for a future task to become due,
+// those tasks are left in the queue. Tasks scheduled with ScheduleWhenIdle
+// run when no other task is due. This allows time-slicing the scheduler from
+// an external loop, e.g. within a frame budget. Called from a task that is
+// being run, WaitBudget returns 0 immediately.
+func (s *trampoline) WaitBudget(maxTasks int, maxTime time.Duration) int {
+	if !s.enter() {
+		return 0
+	}
+	defer s.leave()
+	start := s.clock.Now()
+	eligible := func(t *futuretask) bool {
+		now := s.clock.Now()
+		return !t.at.After(now) && (maxTime <= 0 || now.Sub(start) < maxTime)
+	}
+	count := 0
+	for maxTasks <= 0 || count < maxTasks {
+		if s.runTask(nil, eligible) {
+			count++
+		} else if !s.settle() {
+			break
+		}
+	}
+	return count
+}
+
+// runTask waits for the task at the head of the queue to become due and then
+// removes and runs it. It returns false when the queue is empty, when the
+// scheduler is paused, when the task at the head is not eligible or when the
+// done channel was closed before the task became due. When eligible is nil,
+// every task is eligible.
+func (s *trampoline) runTask(done <-chan struct{}, eligible func(*futuretask) bool) bool {
+	s.guard()
+	s.prune()
+	task := s.tasks.peek()
+	if len(s.tasks.idle) > 0 {
+		if idle := s.tasks.peekIdle(s.clock.Now()); idle != nil {
+			task = idle
+		}
+	}
+	if task == nil || s.IsPaused() || eligible != nil && !eligible(task) {
+		return false
+	}
+	switch _, ok := s.clock.(realtime); {
+	case s.window > 0 && !task.at.After(s.until):
+		// Coalesced with the task that was waited for last.
+	case ok && time.Until(task.at) < 999*time.Millisecond:
+		// Spin waiting only makes sense against the real-time clock.
+		if !s.shortWait(task, done) {
+			return false
+		}
+	default:
+		if !s.longWait(task, done) {
+			return false
+		}
+	}
+	if s.window > 0 && task.at.After(s.until) {
+		s.until = s.clock.Now().Add(s.window)
+	}
+	if s.IsPaused() {
+		return false
+	}
+	s.dispatch(task)
+	return true
+}
+
+// dispatch removes the task from the queue and runs it, unless it was
+// cancelled or its deadline has passed.
+func (s *trampoline) dispatch(task *futuretask) {
+	s.batch = time.Time{}
+	s.tasks.remove(task)
+	s.sync()
+	if task.round > s.round {
+		s.round = task.round
+	}
+	if ctx := task.ext().ctx; ctx != nil && ctx.Err() != nil {
+		task.CancelCause(ctx.Err())
+	}
+	if s.started.IsZero() {
+		s.started = s.clock.Now()
+	}
+	s.current = task
+	switch {
+	case task.IsCancelled():
+		s.stats.Cancelled++
+		s.resolve(task, false)
+	case !task.ext().deadline.IsZero() && s.clock.Now().After(task.ext().deadline):
+		s.stats.Dropped++
+		task.drop()
+		if s.dropped != nil {
+			s.dropped(task)
+		}
+		s.resolve(task, false)
+	case !task.begin():
+		// Cancelled from another goroutine since the check above.
+		s.stats.Cancelled++
+		s.resolve(task, false)
 	default:
+		s.stats.Run++
+		s.run(task)
+		if task.queued() {
+			task.rearm()
+		} else {
+			task.finish()
+			s.resolve(task, true)
+		}
+	}
+	s.current = nil
+	s.tally()
+}
+
+func (s *trampoline) run(task *futuretask) {
+	if s.recover != nil {
+		defer func() {
+			if e := recover(); e != nil {
+				s.recover(e)
+			}
+		}()
+	}
+	if s.before != nil {
+		s.before()
+	}
+	if s.after != nil {
+		defer s.after()
+	}
+	if x := task.more; x != nil && x.rest != nil {
+		rest := x.rest
+		x.rest = nil
+		rest()
+	} else {
 		task.run()
 	}
 }
 
-func (s *trampoline) LongWaitAndRun(task *futuretask) {
-	due := time.Until(task.at)
-	if due > 0 {
-		deadline := time.NewTimer(due)
+func (s *trampoline) shortWait(task *futuretask, done <-chan struct{}) bool {
+	for time.Now().Before(task.at) {
+		if task.IsCancelled() {
+			return true
+		}
+		if s.IsPaused() {
+			return false
+		}
 		select {
-		case <-task.cancel:
-			deadline.Stop()
-			return
-		case <-deadline.C:
-			task.run()
-			return
+		case <-task.ctxDone():
+			return true
+		case <-done:
+			return false
+		default:
+			runtime.Gosched()
 		}
 	}
+	return true
+}
+
+func (s *trampoline) longWait(task *futuretask, done <-chan struct{}) bool {
+	if task.IsCancelled() {
+		return true
+	}
 	select {
-	case <-task.cancel:
-		return
+	case <-task.ctxDone():
+		return true
 	default:
-		task.run()
 	}
+	if due := task.at.Sub(s.clock.Now()); due > 0 {
+		// Should the clock have jumped back since the task was scheduled,
+		// never wait longer than the task was scheduled to wait for.
+		if due > task.delay {
+			due = task.delay
+		}
+		fired, stop := startTimer(s.clock, due)
+		defer stop()
+		select {
+		case <-task.cancelChan():
+		case <-task.ctxDone():
+		case <-fired:
+		case <-done:
+			return false
+		case <-s.halt:
+			return false
+		case <-s.pauseChan():
+			return false
+		}
+	}
+	return true
 }
 
 func (s *trampoline) IsConcurrent() bool {
@@ -177,12 +2246,75 @@ func (s *trampoline) IsConcurrent() bool {
 
 func (s *trampoline) Count() int {
 	if s.current == nil {
-		return len(s.tasks)
+		return s.tasks.Len()
 	} else {
-		return len(s.tasks) + 1
+		return s.tasks.Len() + 1
+	}
+}
+
+func (s *trampoline) String() string {
+	return fmt.Sprintf("Trampoline{ gid = %s, tasks = %d }", s.gid, s.SafeLen())
+}
+
+// Snapshot returns a description of the pending tasks in the order they will
+// be dispatched, with their due times relative to the current time. Together
+// with Restore it allows checkpointing a workload, e.g. a simulation driven by
+// a virtual time scheduler, and resuming it later.
+func (s *trampoline) Snapshot() []TaskInfo {
+	now := s.clock.Now()
+	var infos []TaskInfo
+	for _, t := range s.tasks.sorted() {
+		offset := t.at.Sub(now)
+		if offset < 0 {
+			offset = 0
+		}
+		infos = append(infos, TaskInfo{offset, t.ext().label, t.priority, t.IsCancelled(), t.index == inidle})
+	}
+	return infos
+}
+
+// Restore schedules a task for every task in a snapshot taken by Snapshot
+// that was not cancelled. The function of every task is looked up by its
+// label in funcs and the task is scheduled with that label and priority to
+// become due after its offset from now, or to wait for the scheduler to
+// become idle like ScheduleWhenIdle does when it was waiting for that. Tasks
+// restored from the same snapshot are dispatched in the same order as before.
+// Restore panics when funcs has no function for the label of a task.
+func (s *trampoline) Restore(infos []TaskInfo, funcs map[string]func()) {
+	for _, info := range infos {
+		if info.Cancelled {
+			continue
+		}
+		run, ok := funcs[info.Label]
+		if !ok {
+			panic(fmt.Sprintf("scheduler: Restore has no task for label %q", info.Label))
+		}
+		t := newtask(run)
+		if info.Label != "" {
+			t.extend().label = info.Label
+		}
+		t.priority = info.Priority
+		if info.Idle {
+			s.enqueueIdle(t)
+		} else {
+			s.enqueueAfter(t, info.Offset)
+		}
 	}
 }
 
-func (s trampoline) String() string {
-	return fmt.Sprintf("Trampoline{ gid = %s, tasks = %d }", s.gid, len(s.tasks))
+// Dump returns a multi-line description of the scheduler listing the pending
+// tasks in the order they will be dispatched. For every task its position in
+// the queue, the duration until it is due, whether it was cancelled and its
+// label, if any, is shown.
+func (s *trampoline) Dump() string {
+	var b strings.Builder
+	b.WriteString(s.String())
+	now := s.clock.Now()
+	for i, t := range s.tasks.sorted() {
+		fmt.Fprintf(&b, "\n\t%d: due = %v, cancelled = %v", i, t.at.Sub(now), t.IsCancelled())
+		if label := t.ext().label; label != "" {
+			fmt.Fprintf(&b, ", label = %q", label)
+		}
+	}
+	return b.String()
 }
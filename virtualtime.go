@@ -0,0 +1,47 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// virtualtime
+
+type virtualtime struct {
+	*trampoline
+	clock *virtualclock
+}
+
+// MakeVirtualTime creates and returns a non-concurrent scheduler that runs
+// all tasks on a single goroutine against a virtual clock. The returned
+// instance implements the Scheduler interface. The clock starts at the time
+// the scheduler was created and only moves forward when Advance or Wait is
+// called, so future tasks run without actually waiting for them.
+//
+// The VirtualTime scheduler is not safe to use from multiple goroutines
+// concurrently. It is intended for use in tests.
+func MakeVirtualTime() *virtualtime {
+	clock := &virtualclock{now: time.Now()}
+	return &virtualtime{MakeTrampolineWithClock(clock), clock}
+}
+
+// Advance moves the virtual clock forward by d, running every task that
+// becomes due within the advanced window in dispatch order. Tasks scheduled
+// by those tasks are also run when they fall within the window. Cancelled
+// tasks are dropped from the queue before the next task is picked, so a task
+// due after the window never runs in its place. The clock never moves back.
+func (s *virtualtime) Advance(d time.Duration) {
+	end := s.clock.now.Add(d)
+	within := func(t *futuretask) bool {
+		return !t.at.After(end)
+	}
+	for s.runTask(nil, within) {
+	}
+	if end.After(s.clock.now) {
+		s.clock.now = end
+	}
+}
+
+func (s *virtualtime) String() string {
+	return fmt.Sprintf("VirtualTime{ gid = %s, tasks = %d }", s.gid, s.SafeLen())
+}